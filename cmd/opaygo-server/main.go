@@ -0,0 +1,51 @@
+// Command opaygo-server serves the JSON API issuing the OpenPAYGO tokens of a fleet of devices.
+// It listens on the loopback interface by default. When the OPAYGO_SERVER_TOKEN environment variable is set, every
+// request must send it as a bearer token.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/wan5xp/openpaygotoken/pkg/tokenserver"
+	"github.com/wan5xp/openpaygotoken/pkg/tokenserver/sqlite"
+)
+
+func main() {
+	address := flag.String("addr", "127.0.0.1:8080", "address to listen on")
+	database := flag.String("db", "opaygo.db", "path of the SQLite database of the devices, empty to keep them in memory")
+	flag.Parse()
+
+	if err := run(*address, *database, os.Getenv("OPAYGO_SERVER_TOKEN")); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves the API until the server fails, closing the database before returning the error.
+func run(address string, database string, token string) error {
+	var repository tokenserver.DeviceRepository = tokenserver.NewMemoryRepository()
+	if database != "" {
+		db, err := sqlite.Open(database)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		repository = db
+	}
+	handler := tokenserver.NewHandler(tokenserver.NewTokenServer(repository))
+	if token != "" {
+		handler = tokenserver.RequireBearerToken(token, handler)
+	} else {
+		log.Print("OPAYGO_SERVER_TOKEN is not set, the API is served without authentication")
+	}
+	server := &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Printf("Listening on %s", address)
+	return server.ListenAndServe()
+}
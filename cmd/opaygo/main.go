@@ -0,0 +1,12 @@
+// Command opaygo generates and decodes OpenPAYGO tokens.
+package main
+
+import (
+	"os"
+
+	"github.com/wan5xp/openpaygotoken/internal/cli"
+)
+
+func main() {
+	os.Exit(cli.Run(os.Args[1:], os.Stdout, os.Stderr))
+}
@@ -6,6 +6,10 @@ require github.com/wan5xp/openpaygotoken/pkg/openpaygotoken v0.0.0-2019010810560
 
 require github.com/wan5xp/openpaygotoken/pkg/simulators v0.0.0-20190108105601-1b9a9b2b2f2f
 
+require github.com/wan5xp/openpaygotoken/pkg/tokenserver v0.0.0-20190108105601-1b9a9b2b2f2f
+
+require github.com/mattn/go-sqlite3 v1.14.22 // indirect
+
 require (
 	github.com/aead/siphash v1.0.1 // indirect
 	golang.org/x/exp v0.0.0-20230905200255-921286631fa9
@@ -14,3 +18,5 @@ require (
 replace github.com/wan5xp/openpaygotoken/pkg/openpaygotoken => ./pkg/openpaygotoken
 
 replace github.com/wan5xp/openpaygotoken/pkg/simulators => ./pkg/simulators
+
+replace github.com/wan5xp/openpaygotoken/pkg/tokenserver => ./pkg/tokenserver
@@ -0,0 +1,148 @@
+// Package cli implements the subcommands of the opaygo command.
+package cli
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// command is a subcommand of opaygo.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string, stdout io.Writer, stderr io.Writer) int
+}
+
+// commands are the subcommands of opaygo, in the order of the usage.
+var commands = []command{
+	{"generate", "generate a standard or extended token", runGenerate},
+	{"decode", "decode a token", runDecode},
+	{"inspect", "explain why a token is accepted or rejected", runInspect},
+	{"recover", "list the tokens meant by a mistyped token", runRecover},
+}
+
+// The exit codes of opaygo.
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// Run runs opaygo with the given arguments, without the program name, and returns the exit code.
+func Run(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return exitUsage
+	}
+	for _, command := range commands {
+		if command.name == args[0] {
+			return command.run(args[1:], stdout, stderr)
+		}
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(stdout)
+		return exitOK
+	}
+	fmt.Fprintf(stderr, "opaygo: unknown command %q\n", args[0])
+	usage(stderr)
+	return exitUsage
+}
+
+// usage prints the list of subcommands.
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: opaygo <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, command := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", command.name, command.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run opaygo <command> -h for the flags of a command.")
+}
+
+// newFlagSet creates the flag set of a subcommand, printing its errors and usage to stderr.
+func newFlagSet(name string, arguments string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet("opaygo "+name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: opaygo %s [flags] %s\n\nFlags:\n", name, arguments)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseFlags parses the arguments of a subcommand and returns the exit code to stop with, if any.
+func parseFlags(flags *flag.FlagSet, args []string) (int, bool) {
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return exitOK, false
+	} else if err != nil {
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// deviceFlags are the flags of the secrets of a device.
+type deviceFlags struct {
+	key          string
+	startingCode int
+}
+
+// register adds the device flags to a flag set.
+func (f *deviceFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&f.key, "key", "", "the 16 bytes of the secret key of the device in hexadecimal")
+	flags.IntVar(&f.startingCode, "starting-code", 0, "the starting code of the device")
+}
+
+// parseKey returns the secret key of the device.
+func (f *deviceFlags) parseKey() (*[16]byte, error) {
+	var key [16]byte
+	decoded, err := hex.DecodeString(f.key)
+	if err != nil || len(decoded) != len(key) {
+		return nil, fmt.Errorf("the key must be 32 hexadecimal digits")
+	}
+	copy(key[:], decoded)
+	return &key, nil
+}
+
+// countsFlag is a flag of comma separated counts.
+type countsFlag []int
+
+func (f *countsFlag) String() string {
+	counts := make([]string, 0, len(*f))
+	for _, count := range *f {
+		counts = append(counts, strconv.Itoa(count))
+	}
+	return strings.Join(counts, ",")
+}
+
+func (f *countsFlag) Set(value string) error {
+	for _, count := range strings.Split(value, ",") {
+		if count = strings.TrimSpace(count); count == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(count)
+		if err != nil {
+			return fmt.Errorf("invalid count %q", count)
+		}
+		*f = append(*f, parsed)
+	}
+	return nil
+}
+
+// fail prints an error and returns the error exit code.
+func fail(stderr io.Writer, name string, err error) int {
+	fmt.Fprintf(stderr, "opaygo %s: %v\n", name, err)
+	return exitError
+}
+
+// writeJSON prints a value as indented JSON.
+func writeJSON(w io.Writer, value interface{}) {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
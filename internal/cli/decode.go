@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+)
+
+// decodedToken is the output of opaygo decode.
+type decodedToken struct {
+	Token      string `json:"token"`
+	Format     string `json:"format"`
+	Count      int    `json:"count"`
+	Value      int    `json:"value"`
+	Kind       string `json:"kind"`
+	OlderToken bool   `json:"older_token"`
+	UsedCounts []int  `json:"used_counts"` // The used counts once the token is applied
+}
+
+// runDecode decodes a token against the state of a device.
+func runDecode(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := newFlagSet("decode", "<token>", stderr)
+	var device deviceFlags
+	device.register(flags)
+	lastCount := flags.Int("last-count", 0, "the count of the last token applied to the device")
+	var usedCounts countsFlag
+	flags.Var(&usedCounts, "used", "the comma separated counts of the tokens used by the device")
+	jsonOutput := flags.Bool("json", false, "print the decoded token as JSON")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	key, err := device.parseKey()
+	if err != nil {
+		return fail(stderr, "decode", err)
+	}
+	token, err := openpaygotoken.ParseToken(flags.Arg(0))
+	if err != nil {
+		return fail(stderr, "decode", err)
+	}
+	decoder, err := openpaygotoken.NewDecoder()
+	if err != nil {
+		return fail(stderr, "decode", err)
+	}
+	used := openpaygotoken.CountWindowFromSlice(usedCounts)
+	result, err := decoder.DecodeWindow(token, device.startingCode, key, *lastCount, used)
+	if err != nil {
+		return fail(stderr, "decode", err)
+	}
+	if token.IsExtended() {
+		decoder.UpdateExtendedCountWindow(&used, result.Count)
+	} else {
+		decoder.UpdateCountWindow(&used, result.Value, result.Count, result.Type)
+	}
+
+	// We drop the negative counts marked by a set time token below the count 0
+	usedAfter := make([]int, 0, openpaygotoken.CountWindowSize)
+	for _, count := range used.Slice() {
+		if count >= 0 {
+			usedAfter = append(usedAfter, count)
+		}
+	}
+	decoded := decodedToken{
+		Token:      token.Digits,
+		Format:     token.Format.String(),
+		Count:      result.Count,
+		Value:      result.Value,
+		Kind:       result.Kind.String(),
+		OlderToken: result.OlderToken,
+		UsedCounts: usedAfter,
+	}
+	if *jsonOutput {
+		writeJSON(stdout, decoded)
+	} else {
+		fmt.Fprintf(stdout, "Token:  %s (%s)\nCount:  %d\nValue:  %d\nKind:   %s\n", decoded.Token, decoded.Format, decoded.Count, decoded.Value, decoded.Kind)
+		if decoded.OlderToken {
+			fmt.Fprintln(stdout, "Older unused token")
+		}
+		fmt.Fprintf(stdout, "Used:   %s\n", (*countsFlag)(&decoded.UsedCounts))
+	}
+	return exitOK
+}
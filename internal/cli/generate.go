@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+)
+
+// generatedToken is the output of opaygo generate.
+type generatedToken struct {
+	Token    string `json:"token"`
+	Count    int    `json:"count"`
+	Value    int    `json:"value"`
+	Kind     string `json:"kind"`
+	Extended bool   `json:"extended"`
+}
+
+// runGenerate generates a standard or extended token.
+func runGenerate(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := newFlagSet("generate", "", stderr)
+	var device deviceFlags
+	device.register(flags)
+	count := flags.Int("count", 0, "the count of the last token generated for the device")
+	tokenType := flags.String("type", "add", "the type of token: add, set, disable or sync")
+	value := flags.Int("value", 0, "the value of an add or set token")
+	extended := flags.Bool("extended", false, "generate an extended token, only of the add type")
+	restricted := flags.Bool("restricted", false, "use the restricted digit set 1 to 4")
+	jsonOutput := flags.Bool("json", false, "print the token as JSON")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return exitUsage
+	}
+	key, err := device.parseKey()
+	if err != nil {
+		return fail(stderr, "generate", err)
+	}
+
+	var token generatedToken
+	mode := openpaygotoken.AddTime
+	kind := openpaygotoken.KindAddTime
+	maxValue := openpaygotoken.MaxActivationValue
+	switch *tokenType {
+	case "add":
+	case "set":
+		mode, kind = openpaygotoken.SetTime, openpaygotoken.KindSetTime
+	case "disable":
+		mode, kind, *value = openpaygotoken.SetTime, openpaygotoken.KindDisable, openpaygotoken.PAYGDisableValue
+	case "sync":
+		kind, *value = openpaygotoken.KindCounterSync, openpaygotoken.CounterSyncValue
+	default:
+		return fail(stderr, "generate", fmt.Errorf("unknown token type %q", *tokenType))
+	}
+	if *extended {
+		if *tokenType != "add" {
+			return fail(stderr, "generate", fmt.Errorf("extended tokens can only add time"))
+		}
+		maxValue = openpaygotoken.MaxExtendedActivationValue
+	}
+	if kind == openpaygotoken.KindAddTime || kind == openpaygotoken.KindSetTime {
+		if *value < 0 || *value > maxValue {
+			return fail(stderr, "generate", fmt.Errorf("the value must be between 0 and %d", maxValue))
+		}
+	}
+	if *extended {
+		token.Count, token.Token, err = openpaygotoken.GenerateExtendedToken(device.startingCode, key, *value, *count, *restricted)
+	} else {
+		token.Count, token.Token, err = openpaygotoken.GenerateStandardToken(device.startingCode, key, *value, *count, mode, *restricted)
+	}
+	if err != nil {
+		return fail(stderr, "generate", err)
+	}
+	token.Value, token.Kind, token.Extended = *value, kind.String(), *extended
+
+	if *jsonOutput {
+		writeJSON(stdout, token)
+	} else {
+		fmt.Fprintf(stdout, "Token: %s\nCount: %d\nValue: %d\nKind:  %s\n", token.Token, token.Count, token.Value, token.Kind)
+	}
+	return exitOK
+}
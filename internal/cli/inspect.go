@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+)
+
+// inspectedToken is the output of opaygo inspect.
+type inspectedToken struct {
+	Token       string        `json:"token,omitempty"`
+	Format      string        `json:"format,omitempty"`
+	Reason      string        `json:"reason"`
+	Explanation string        `json:"explanation"`
+	Match       *matchedToken `json:"match,omitempty"`
+	LastCount   int           `json:"last_count"`
+	ScanLimit   int           `json:"scan_limit"`
+}
+
+// matchedToken is a count at which the token is generated.
+type matchedToken struct {
+	Count int    `json:"count"`
+	Value int    `json:"value"`
+	Kind  string `json:"kind"`
+	Used  bool   `json:"used"`
+}
+
+// runInspect explains why a token is accepted or rejected by a device.
+func runInspect(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := newFlagSet("inspect", "<token>", stderr)
+	var device deviceFlags
+	device.register(flags)
+	lastCount := flags.Int("last-count", 0, "the count of the last token applied to the device")
+	var usedCounts countsFlag
+	flags.Var(&usedCounts, "used", "the comma separated counts of the tokens used by the device")
+	jsonOutput := flags.Bool("json", false, "print the diagnosis as JSON")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	key, err := device.parseKey()
+	if err != nil {
+		return fail(stderr, "inspect", err)
+	}
+	decoder, err := openpaygotoken.NewDecoder()
+	if err != nil {
+		return fail(stderr, "inspect", err)
+	}
+	diagnosis := decoder.Diagnose(flags.Arg(0), device.startingCode, key, *lastCount, openpaygotoken.CountWindowFromSlice(usedCounts))
+
+	inspected := inspectedToken{
+		Reason:      diagnosis.Reason.String(),
+		Explanation: diagnosis.Explain(),
+		LastCount:   diagnosis.LastCount,
+		ScanLimit:   diagnosis.ScanLimit,
+	}
+	if diagnosis.Token != nil {
+		inspected.Token, inspected.Format = diagnosis.Token.Digits, diagnosis.Token.Format.String()
+	}
+	if match := diagnosis.Match; match != nil {
+		inspected.Match = &matchedToken{Count: match.Count, Value: match.Value, Kind: match.Kind.String(), Used: match.Used}
+	}
+	if *jsonOutput {
+		writeJSON(stdout, inspected)
+		return exitOK
+	}
+	fmt.Fprintf(stdout, "Reason: %s\n%s\n", inspected.Reason, inspected.Explanation)
+	if inspected.Match != nil {
+		fmt.Fprintf(stdout, "Match:  count %d, value %d, kind %s, used %t\n", inspected.Match.Count, inspected.Match.Value, inspected.Match.Kind, inspected.Match.Used)
+	}
+	return exitOK
+}
@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+)
+
+// recoveredToken is a candidate printed by opaygo recover.
+type recoveredToken struct {
+	Token    string `json:"token"`
+	Edit     string `json:"edit"`
+	Position int    `json:"position"`
+	Count    int    `json:"count"`
+	Value    int    `json:"value"`
+	Kind     string `json:"kind"`
+}
+
+// runRecover lists the tokens a user may have meant to enter instead of a mistyped token.
+func runRecover(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := newFlagSet("recover", "<token>", stderr)
+	var device deviceFlags
+	device.register(flags)
+	lastCount := flags.Int("last-count", 0, "the count of the last token applied to the device")
+	var usedCounts countsFlag
+	flags.Var(&usedCounts, "used", "the comma separated counts of the tokens used by the device")
+	jsonOutput := flags.Bool("json", false, "print the candidates as JSON")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	key, err := device.parseKey()
+	if err != nil {
+		return fail(stderr, "recover", err)
+	}
+	decoder, err := openpaygotoken.NewDecoder()
+	if err != nil {
+		return fail(stderr, "recover", err)
+	}
+	candidates, err := decoder.RecoverToken(flags.Arg(0), device.startingCode, key, *lastCount, openpaygotoken.CountWindowFromSlice(usedCounts))
+	if err != nil {
+		return fail(stderr, "recover", err)
+	}
+
+	recovered := make([]recoveredToken, 0, len(candidates))
+	for _, candidate := range candidates {
+		recovered = append(recovered, recoveredToken{
+			Token:    candidate.Token,
+			Edit:     candidate.Edit.String(),
+			Position: candidate.Position,
+			Count:    candidate.Result.Count,
+			Value:    candidate.Result.Value,
+			Kind:     candidate.Result.Kind.String(),
+		})
+	}
+	if *jsonOutput {
+		writeJSON(stdout, struct {
+			Candidates []recoveredToken `json:"candidates"`
+		}{recovered})
+	} else {
+		printCandidates(stdout, recovered)
+	}
+	if len(recovered) == 0 {
+		return exitError
+	}
+	return exitOK
+}
+
+// printCandidates prints the ranked candidates of opaygo recover.
+func printCandidates(w io.Writer, candidates []recoveredToken) {
+	if len(candidates) == 0 {
+		fmt.Fprintln(w, "No valid token differs from the input by one digit or two swapped digits")
+	}
+	for rank, candidate := range candidates {
+		edit := "as entered"
+		switch candidate.Edit {
+		case openpaygotoken.EditSubstitution.String():
+			edit = fmt.Sprintf("digit %d replaced", candidate.Position+1)
+		case openpaygotoken.EditTransposition.String():
+			edit = fmt.Sprintf("digits %d and %d swapped", candidate.Position+1, candidate.Position+2)
+		}
+		fmt.Fprintf(w, "%d. %s (%s): count %d, value %d, kind %s\n", rank+1, candidate.Token, edit, candidate.Count, candidate.Value, candidate.Kind)
+	}
+}
@@ -0,0 +1,132 @@
+package openpaygotoken
+
+const defaultMaxChainCheckpoints int = 64
+
+// ChainCheckpoint is a position in the hash chain of a token base.
+type ChainCheckpoint struct {
+	Count int    `json:"count"`
+	Code  uint64 `json:"code"`
+}
+
+// TokenChain generates the tokens of a device from checkpoints in its hash chains.
+// The hash chain starts from the starting code with the token base in it, so there is one chain for each token
+// base and a checkpoint is kept for each base used. Generating the next token of an already used base only needs
+// the hashes between the checkpoint and the new count. The chains of the bases share no hash, so the first token of a
+// base, or a count below its checkpoint, needs the hashes from the count 0: the cost is amortized O(1) per count only
+// for increasing counts of the bases already used. The chain does not serialize the key.
+type TokenChain struct {
+	StartingCode        int                     `json:"starting_code"`
+	MaxCheckpoints      int                     `json:"max_checkpoints"`
+	Checkpoints         map[int]ChainCheckpoint `json:"checkpoints"`
+	ExtendedCheckpoints map[int]ChainCheckpoint `json:"extended_checkpoints"`
+	key                 [16]byte
+}
+
+// NewTokenChain creates a new TokenChain for a device.
+func NewTokenChain(startingCode int, key *[16]byte) *TokenChain {
+	return &TokenChain{
+		StartingCode:        startingCode,
+		MaxCheckpoints:      defaultMaxChainCheckpoints,
+		Checkpoints:         make(map[int]ChainCheckpoint),
+		ExtendedCheckpoints: make(map[int]ChainCheckpoint),
+		key:                 *key,
+	}
+}
+
+// SetKey sets the key of a chain decoded from JSON, which does not hold the key.
+func (c *TokenChain) SetKey(key *[16]byte) {
+	c.key = *key
+}
+
+// GenerateStandardToken generates a token as GenerateStandardToken does, starting from the checkpoint of the token base.
+// This function returns the count, the token and an error if there is one.
+func (c *TokenChain) GenerateStandardToken(value int, count int, mode TokenType, restrictedDigitSet bool) (int, string, error) {
+	tokenBase := encodeBase(getTokenBase(c.StartingCode), value)
+	newCount := getNewCount(count, mode)
+	currentToken, err := c.StandardCode(tokenBase, newCount)
+	if err != nil {
+		return 0, "", err
+	}
+	finalToken, err := putBaseInToken(currentToken, tokenBase)
+	if err != nil {
+		return 0, "", err
+	}
+	return newCount, formatStandardToken(finalToken, restrictedDigitSet), nil
+}
+
+// GenerateExtendedToken generates a token as GenerateExtendedToken does, starting from the checkpoint of the token base.
+// This function returns the count, the token and an error if there is one.
+func (c *TokenChain) GenerateExtendedToken(value int, count int, restrictedDigitSet bool) (int, string, error) {
+	tokenBase := encodeBaseExtended(getTokenBaseExtended(uint64(c.StartingCode)), value)
+	newCount := count + 1
+	currentToken, err := c.ExtendedCode(tokenBase, newCount)
+	if err != nil {
+		return 0, "", err
+	}
+	finalToken, err := putBaseInTokenExtended(currentToken, tokenBase)
+	if err != nil {
+		return 0, "", err
+	}
+	return newCount, formatExtendedToken(finalToken, restrictedDigitSet), nil
+}
+
+// StandardCode returns the unmasked code at the given count of the standard hash chain of a token base.
+func (c *TokenChain) StandardCode(tokenBase int, count int) (int, error) {
+	checkpoint, ok := c.Checkpoints[tokenBase]
+	below := ok && checkpoint.Count > count
+	if !ok || below {
+		startingCode, err := putBaseInToken(c.StartingCode, tokenBase)
+		if err != nil {
+			return 0, err
+		}
+		checkpoint = ChainCheckpoint{Count: 0, Code: uint64(startingCode)}
+	}
+	currentCode := int(checkpoint.Code)
+	for xn := checkpoint.Count; xn < count; xn++ {
+		currentCode = generateNextToken(currentCode, &c.key)
+	}
+	// We keep the higher checkpoint, the next counts are usually above it
+	if !below {
+		c.Checkpoints = c.storeCheckpoint(c.Checkpoints, tokenBase, ChainCheckpoint{Count: count, Code: uint64(currentCode)})
+	}
+	return currentCode, nil
+}
+
+// ExtendedCode returns the unmasked code at the given count of the extended hash chain of a token base.
+func (c *TokenChain) ExtendedCode(tokenBase int, count int) (uint64, error) {
+	checkpoint, ok := c.ExtendedCheckpoints[tokenBase]
+	below := ok && checkpoint.Count > count
+	if !ok || below {
+		startingCode, err := putBaseInTokenExtended(uint64(c.StartingCode), tokenBase)
+		if err != nil {
+			return 0, err
+		}
+		checkpoint = ChainCheckpoint{Count: 0, Code: startingCode}
+	}
+	currentCode := checkpoint.Code
+	for xn := checkpoint.Count; xn < count; xn++ {
+		currentCode = generateNextTokenExtended(currentCode, &c.key)
+	}
+	if !below {
+		c.ExtendedCheckpoints = c.storeCheckpoint(c.ExtendedCheckpoints, tokenBase, ChainCheckpoint{Count: count, Code: currentCode})
+	}
+	return currentCode, nil
+}
+
+// storeCheckpoint stores the checkpoint of a token base, dropping the checkpoint with the lowest count when full.
+func (c *TokenChain) storeCheckpoint(checkpoints map[int]ChainCheckpoint, tokenBase int, checkpoint ChainCheckpoint) map[int]ChainCheckpoint {
+	if checkpoints == nil {
+		checkpoints = make(map[int]ChainCheckpoint)
+	}
+	if _, ok := checkpoints[tokenBase]; !ok && c.MaxCheckpoints > 0 && len(checkpoints) >= c.MaxCheckpoints {
+		lowestBase, found := 0, false
+		for base, existing := range checkpoints {
+			if !found || existing.Count < checkpoints[lowestBase].Count {
+				lowestBase, found = base, true
+			}
+		}
+		delete(checkpoints, lowestBase)
+	}
+	checkpoints[tokenBase] = checkpoint
+	return checkpoints
+}
@@ -0,0 +1,60 @@
+package openpaygotoken
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// RealClock is the clock of the system.
+type RealClock struct {
+}
+
+// Now returns the current time of the system.
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+// FakeClock is a clock that only moves when it is told to.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock creates a new FakeClock stopped at the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the time of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by the given duration.
+func (c *FakeClock) Advance(duration time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(duration)
+}
+
+// Set moves the clock to the given time.
+func (c *FakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// NowFrom returns the time of the clock, or the time of the system if there is no clock.
+func NowFrom(clock Clock) time.Time {
+	if clock == nil {
+		return time.Now()
+	}
+	return clock.Now()
+}
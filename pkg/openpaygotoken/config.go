@@ -0,0 +1,100 @@
+package openpaygotoken
+
+const (
+	maxDecoderWindow int = 10000
+)
+
+// DecoderConfig is the configuration of a TokenDecoder.
+type DecoderConfig struct {
+	MaxTokenJump            int // How far above the last count a token can be
+	MaxTokenJumpCounterSync int // How far above the last count a counter sync token can be
+	MaxUnusedOlderToken     int // How far below the last count an unused add time token is still accepted, 0 to disable
+	CounterSyncLookback     int // How far below the last count a counter sync token is still accepted
+}
+
+// OpenPAYGODecoderConfig returns the configuration of the OpenPAYGO reference implementation.
+func OpenPAYGODecoderConfig() DecoderConfig {
+	return DecoderConfig{
+		MaxTokenJump:            64,
+		MaxTokenJumpCounterSync: 100,
+		MaxUnusedOlderToken:     8 * 2,
+		CounterSyncLookback:     30,
+	}
+}
+
+// NoOlderTokenDecoderConfig returns the configuration of the OpenPAYGO reference implementation with the older
+// tokens disabled, every token must be entered in order.
+func NoOlderTokenDecoderConfig() DecoderConfig {
+	config := OpenPAYGODecoderConfig()
+	config.MaxUnusedOlderToken = 0
+	return config
+}
+
+// Validate returns an error if a value of the configuration is out of range.
+// The older tokens window must fit in a CountWindow.
+func (c DecoderConfig) Validate() error {
+	if c.MaxTokenJump < 1 || c.MaxTokenJump > maxDecoderWindow {
+		return &ErrInvalidDecoderConfig{Field: "MaxTokenJump", Value: c.MaxTokenJump}
+	}
+	if c.MaxTokenJumpCounterSync < c.MaxTokenJump || c.MaxTokenJumpCounterSync > maxDecoderWindow {
+		return &ErrInvalidDecoderConfig{Field: "MaxTokenJumpCounterSync", Value: c.MaxTokenJumpCounterSync}
+	}
+	if c.MaxUnusedOlderToken < 0 || c.MaxUnusedOlderToken >= CountWindowSize {
+		return &ErrInvalidDecoderConfig{Field: "MaxUnusedOlderToken", Value: c.MaxUnusedOlderToken}
+	}
+	if c.CounterSyncLookback < 0 || c.CounterSyncLookback > maxDecoderWindow {
+		return &ErrInvalidDecoderConfig{Field: "CounterSyncLookback", Value: c.CounterSyncLookback}
+	}
+	return nil
+}
+
+// CounterSyncNeeded returns true if the next token of a server at the given count is too far above the count
+// reported by the device to be accepted, so a counter sync token must be entered first.
+// If the device is too far behind even for a counter sync token, ErrCounterSyncOutOfReach is returned with true.
+func (c DecoderConfig) CounterSyncNeeded(serverCount int, deviceCount int) (bool, error) {
+	if serverCount+2 <= deviceCount+c.MaxTokenJump {
+		return false, nil
+	}
+	if serverCount+2 > deviceCount+c.MaxTokenJumpCounterSync {
+		return true, &ErrCounterSyncOutOfReach{DeviceCount: deviceCount, ServerCount: serverCount}
+	}
+	return true, nil
+}
+
+// DecoderOption is an option of NewDecoder.
+type DecoderOption func(*DecoderConfig)
+
+// WithConfig replaces the whole configuration of the decoder.
+func WithConfig(config DecoderConfig) DecoderOption {
+	return func(c *DecoderConfig) {
+		*c = config
+	}
+}
+
+// WithMaxTokenJump sets how far above the last count a token can be.
+func WithMaxTokenJump(maxTokenJump int) DecoderOption {
+	return func(c *DecoderConfig) {
+		c.MaxTokenJump = maxTokenJump
+	}
+}
+
+// WithMaxTokenJumpCounterSync sets how far above the last count a counter sync token can be.
+func WithMaxTokenJumpCounterSync(maxTokenJumpCounterSync int) DecoderOption {
+	return func(c *DecoderConfig) {
+		c.MaxTokenJumpCounterSync = maxTokenJumpCounterSync
+	}
+}
+
+// WithMaxUnusedOlderToken sets how far below the last count an unused add time token is still accepted.
+func WithMaxUnusedOlderToken(maxUnusedOlderToken int) DecoderOption {
+	return func(c *DecoderConfig) {
+		c.MaxUnusedOlderToken = maxUnusedOlderToken
+	}
+}
+
+// WithCounterSyncLookback sets how far below the last count a counter sync token is still accepted.
+func WithCounterSyncLookback(counterSyncLookback int) DecoderOption {
+	return func(c *DecoderConfig) {
+		c.CounterSyncLookback = counterSyncLookback
+	}
+}
@@ -0,0 +1,155 @@
+package openpaygotoken
+
+// CountWindowSize is the number of counts a CountWindow can hold below its highest count, included.
+const CountWindowSize int = 64
+
+// CountWindow is the set of used counts, kept as the highest used count and a bitmask of the counts below it.
+// The bit i of the mask is set when the count Highest-i is used.
+type CountWindow struct {
+	Highest int
+	Mask    uint64
+}
+
+// CountWindowFromSlice returns the window of the given used counts.
+// Counts more than CountWindowSize-1 below the highest count are dropped.
+func CountWindowFromSlice(usedCounts []int) CountWindow {
+	var window CountWindow
+	if len(usedCounts) > 0 {
+		window.Highest = usedCounts[0]
+	}
+	for _, count := range usedCounts {
+		if count > window.Highest {
+			window.Highest = count
+		}
+	}
+	for _, count := range usedCounts {
+		window.set(count)
+	}
+	return window
+}
+
+// Slice returns the used counts in increasing order.
+func (w CountWindow) Slice() []int {
+	usedCounts := make([]int, 0)
+	for offset := CountWindowSize - 1; offset >= 0; offset-- {
+		if w.Mask&(1<<uint(offset)) != 0 {
+			usedCounts = append(usedCounts, w.Highest-offset)
+		}
+	}
+	return usedCounts
+}
+
+// Contains returns true if the count is used.
+func (w CountWindow) Contains(count int) bool {
+	offset := w.Highest - count
+	return offset >= 0 && offset < CountWindowSize && w.Mask&(1<<uint(offset)) != 0
+}
+
+// Update marks the new count as used, or every count down to the window size below it if markAll is set, and
+// forgets the counts more than window below the highest count.
+func (w *CountWindow) Update(newCount int, markAll bool, window int) {
+	if newCount > w.Highest {
+		shift := newCount - w.Highest
+		if shift >= CountWindowSize {
+			w.Mask = 0
+		} else {
+			w.Mask <<= uint(shift)
+		}
+		w.Highest = newCount
+	}
+	if markAll {
+		w.Mask = ^uint64(0)
+	} else {
+		w.set(newCount)
+	}
+	if window+1 < CountWindowSize {
+		w.Mask &= 1<<uint(window+1) - 1
+	}
+}
+
+// MarshalJSON returns the used counts as a JSON list.
+func (w CountWindow) MarshalJSON() ([]byte, error) {
+	data := []byte{'['}
+	for xn, count := range w.Slice() {
+		if xn > 0 {
+			data = append(data, ',')
+		}
+		data = append(data, formatInt(count)...)
+	}
+	return append(data, ']'), nil
+}
+
+// UnmarshalJSON reads the used counts from a JSON list of integers, or null.
+func (w *CountWindow) UnmarshalJSON(data []byte) error {
+	data = trimJSONSpaces(data)
+	if string(data) == "null" {
+		*w = CountWindow{}
+		return nil
+	}
+	if len(data) < 2 || data[0] != '[' || data[len(data)-1] != ']' {
+		return &ErrInvalidCountWindow{}
+	}
+	usedCounts := make([]int, 0)
+	body := trimJSONSpaces(data[1 : len(data)-1])
+	for more := len(body) > 0; more; {
+		item := body
+		more = false
+		for xn, c := range body {
+			if c == ',' {
+				item, body, more = body[:xn], body[xn+1:], true
+				break
+			}
+		}
+		count, ok := parseJSONInt(trimJSONSpaces(item))
+		if !ok {
+			return &ErrInvalidCountWindow{}
+		}
+		usedCounts = append(usedCounts, count)
+	}
+	*w = CountWindowFromSlice(usedCounts)
+	return nil
+}
+
+// trimJSONSpaces returns the data without the JSON white spaces around it.
+func trimJSONSpaces(data []byte) []byte {
+	isSpace := func(c byte) bool {
+		return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+	}
+	for len(data) > 0 && isSpace(data[0]) {
+		data = data[1:]
+	}
+	for len(data) > 0 && isSpace(data[len(data)-1]) {
+		data = data[:len(data)-1]
+	}
+	return data
+}
+
+// parseJSONInt parses a JSON integer of at most 18 digits, false if the data is not one.
+func parseJSONInt(data []byte) (int, bool) {
+	negative := len(data) > 0 && data[0] == '-'
+	if negative {
+		data = data[1:]
+	}
+	if len(data) == 0 || len(data) > 18 {
+		return 0, false
+	}
+	number := 0
+	for _, c := range data {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		number = number*10 + int(c-'0')
+	}
+	if negative {
+		return -number, true
+	}
+	return number, true
+}
+
+// set marks a count at or below the highest count as used.
+func (w *CountWindow) set(count int) {
+	offset := w.Highest - count
+	if offset >= 0 && offset < CountWindowSize {
+		w.Mask |= 1 << uint(offset)
+	}
+}
@@ -1,52 +1,174 @@
 package openpaygotoken
 
-import (
-	"fmt"
-	"strconv"
-
-	"golang.org/x/exp/slices"
-)
+import "errors"
 
+// TokenDecoder decodes the tokens entered in a device.
 type TokenDecoder struct {
 	maxTokenJump            int
 	maxTokenJumpCounterSync int
 	maxUnusedOlderToken     int
+	counterSyncLookback     int
 }
 
-const (
-	defaultMaxTokenJumpCounterSync int = 100
-	defaultMaxTokenJump            int = 64
-	defaultMaxUnusedOldToken       int = 8 * 2
-)
-
-// NewDecoder creates a new TokenDecoder with the given parameters.
-// If no parameters are given, the default values are used.
-// If only one parameter is given, it is used as maxTokenJump.
-// If two parameters are given, the first is used as maxTokenJump and the second as maxTokenJumpCounterSync.
-// If three or more parameters are given, the first is used as maxTokenJump, the second as maxTokenJumpCounterSync and the third as maxUnusedOlderToken.
-func NewDecoder(args ...int) (*TokenDecoder, error) {
-	if len(args) == 0 {
-		return &TokenDecoder{maxTokenJump: defaultMaxTokenJump, maxTokenJumpCounterSync: defaultMaxTokenJumpCounterSync, maxUnusedOlderToken: defaultMaxUnusedOldToken}, nil
-	} else if len(args) == 1 {
-		return &TokenDecoder{maxTokenJump: args[0], maxTokenJumpCounterSync: defaultMaxTokenJumpCounterSync, maxUnusedOlderToken: defaultMaxUnusedOldToken}, nil
-	} else if len(args) == 2 {
-		return &TokenDecoder{maxTokenJump: args[0], maxTokenJumpCounterSync: args[1], maxUnusedOlderToken: defaultMaxUnusedOldToken}, nil
-	} else {
-		return &TokenDecoder{maxTokenJump: args[0], maxTokenJumpCounterSync: args[1], maxUnusedOlderToken: args[2]}, nil
+// NewDecoder creates a new TokenDecoder with the OpenPAYGO reference configuration changed by the given options.
+// If the resulting configuration is not valid, an ErrInvalidDecoderConfig is returned.
+func NewDecoder(options ...DecoderOption) (*TokenDecoder, error) {
+	config := OpenPAYGODecoderConfig()
+	for _, option := range options {
+		option(&config)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &TokenDecoder{
+		maxTokenJump:            config.MaxTokenJump,
+		maxTokenJumpCounterSync: config.MaxTokenJumpCounterSync,
+		maxUnusedOlderToken:     config.MaxUnusedOlderToken,
+		counterSyncLookback:     config.CounterSyncLookback,
+	}, nil
+}
+
+// Config returns the configuration of the decoder.
+func (d *TokenDecoder) Config() DecoderConfig {
+	return DecoderConfig{
+		MaxTokenJump:            d.maxTokenJump,
+		MaxTokenJumpCounterSync: d.maxTokenJumpCounterSync,
+		MaxUnusedOlderToken:     d.maxUnusedOlderToken,
+		CounterSyncLookback:     d.counterSyncLookback,
 	}
 }
 
+// DecodeResult is the result of a decoded token.
+type DecodeResult struct {
+	Value      int
+	Count      int
+	Type       TokenType
+	Kind       TokenKind
+	OlderToken bool // The token count is not above the last count but the token was never used
+}
+
 // GetActivationValueCountAndTypeFromToken returns the value, count and type of the token.
+// If the token is valid but was already used, the value -2 is returned.
 // If the token is not valid, an error is returned.
+//
+// Deprecated: Use DecodeToken instead.
 func (d *TokenDecoder) GetActivationValueCountAndTypeFromToken(token int, startingCode int, key *[16]byte, lastCount int, restrictedDigitSet bool, usedCounts *[]int) (int, int, TokenType, error) {
+	result, err := d.DecodeToken(token, startingCode, key, lastCount, restrictedDigitSet, usedCounts)
+	if errors.Is(err, &ErrValidOlderToken{}) {
+		return -2, 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return result.Value, result.Count, result.Type, nil
+}
+
+// DecodeToken decodes a standard token.
+// If the token is valid but was already used, ErrValidOlderToken is returned.
+// If the token is valid but older than the accepted window, ErrTokenOutOfWindow is returned.
+// If the token is not valid, ErrInvalidToken is returned.
+func (d *TokenDecoder) DecodeToken(token int, startingCode int, key *[16]byte, lastCount int, restrictedDigitSet bool, usedCounts *[]int) (*DecodeResult, error) {
 	if restrictedDigitSet {
-		token = int(convertFrom4DigitToken(token))
+		code, err := convertFrom4DigitToken(uint64(token))
+		if err != nil {
+			return nil, err
+		}
+		token = int(code)
+	}
+	result := &DecodeResult{}
+	if err := d.decodeStandardCode(result, token, startingCode, key, lastCount, windowFromSlice(usedCounts), nil); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Decode decodes a parsed standard or extended token.
+func (d *TokenDecoder) Decode(token *Token, startingCode int, key *[16]byte, lastCount int, usedCounts *[]int) (*DecodeResult, error) {
+	return d.DecodeWindow(token, startingCode, key, lastCount, windowFromSlice(usedCounts))
+}
+
+// DecodeWindow decodes a parsed token as Decode does, with the used counts kept in a CountWindow.
+func (d *TokenDecoder) DecodeWindow(token *Token, startingCode int, key *[16]byte, lastCount int, used CountWindow) (*DecodeResult, error) {
+	result := &DecodeResult{}
+	if err := d.DecodeInto(result, token, startingCode, key, lastCount, used); err != nil {
+		return nil, err
 	}
-	validOlderToken := false
+	return result, nil
+}
+
+// DecodeInto decodes a parsed token as DecodeWindow does into the given result, without allocating.
+func (d *TokenDecoder) DecodeInto(result *DecodeResult, token *Token, startingCode int, key *[16]byte, lastCount int, used CountWindow) error {
+	if !token.IsExtended() {
+		return d.decodeStandardCode(result, int(token.Code), startingCode, key, lastCount, used, nil)
+	}
+	return d.decodeExtendedCode(result, token.Code, startingCode, key, lastCount, used, nil)
+}
+
+// DecodeDigits parses and decodes a token entered by a user into the given result, without allocating.
+// It is meant for firmwares, where the token entered on the keypad is decoded against the saved used counts.
+// Only the errors of malformed input, which carry the faulty digit, are allocated.
+func (d *TokenDecoder) DecodeDigits(result *DecodeResult, input string, startingCode int, key *[16]byte, lastCount int, used CountWindow) error {
+	code, format, err := ParseTokenCode(input)
+	if err != nil {
+		return err
+	}
+	token := Token{Format: format, Code: code}
+	return d.DecodeInto(result, &token, startingCode, key, lastCount, used)
+}
+
+// DecodeWithChain decodes a token as Decode does, with the starting code and key of the chain.
+// The search starts from the checkpoint of the token base in the chain instead of the count 0, and a checkpoint is
+// stored MaxUnusedOlderToken below the lowest count that can still be accepted. The results are the same as Decode,
+// except for the tokens older than the checkpoint: they are not searched and return ErrInvalidToken instead of
+// ErrTokenOutOfWindow, so an invalid token costs at most the windows and MaxUnusedOlderToken hashes.
+func (d *TokenDecoder) DecodeWithChain(token *Token, chain *TokenChain, lastCount int, usedCounts *[]int) (*DecodeResult, error) {
+	return d.DecodeWindowWithChain(token, chain, lastCount, windowFromSlice(usedCounts))
+}
+
+// DecodeWindowWithChain decodes a parsed token as DecodeWithChain does, with the used counts kept in a CountWindow.
+func (d *TokenDecoder) DecodeWindowWithChain(token *Token, chain *TokenChain, lastCount int, used CountWindow) (*DecodeResult, error) {
+	result := &DecodeResult{}
+	var err error
+	if !token.IsExtended() {
+		err = d.decodeStandardCode(result, int(token.Code), chain.StartingCode, &chain.key, lastCount, used, chain)
+	} else {
+		err = d.decodeExtendedCode(result, token.Code, chain.StartingCode, &chain.key, lastCount, used, chain)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// chainCheckpointCount returns the count of the checkpoints stored in a chain: MaxUnusedOlderToken below the lowest
+// count of a token that can still be accepted, so the tokens just below the windows are told apart from the invalid
+// tokens.
+func (d *TokenDecoder) chainCheckpointCount(lastCount int) int {
+	lookback := d.counterSyncLookback
+	if d.maxUnusedOlderToken > lookback {
+		lookback = d.maxUnusedOlderToken
+	}
+	if lastCount-lookback-d.maxUnusedOlderToken < 0 {
+		return 0
+	}
+	return lastCount - lookback - d.maxUnusedOlderToken
+}
+
+// decodeStandardCode decodes a standard token code into the result.
+// If a chain is given, the search starts from its checkpoint and a new checkpoint is stored in it.
+func (d *TokenDecoder) decodeStandardCode(result *DecodeResult, token int, startingCode int, key *[16]byte, lastCount int, used CountWindow, chain *TokenChain) error {
+	usedTokenFound := false
+	outOfWindowTokenFound := false
 	tokenBase := getTokenBase(token)                            // We get the base of the token
 	currentCode, err := putBaseInToken(startingCode, tokenBase) // We put the base in the starting code
 	if err != nil {
-		return 0, 0, 0, err
+		return err
+	}
+	startCount, checkpointCount := 0, d.chainCheckpointCount(lastCount)
+	if chain != nil {
+		if checkpoint, ok := chain.Checkpoints[tokenBase]; ok && checkpoint.Count <= checkpointCount {
+			startCount, currentCode = checkpoint.Count, int(checkpoint.Code)
+		}
 	}
 	startingCodeBase := getTokenBase(startingCode)   // We get the base of the starting code
 	value := decodeBase(startingCodeBase, tokenBase) // If there is a match we get the value from the token
@@ -58,10 +180,13 @@ func (d *TokenDecoder) GetActivationValueCountAndTypeFromToken(token int, starti
 	} else {
 		maxCountTry = lastCount + d.maxTokenJump + 1
 	}
-	for count := 0; count < maxCountTry; count++ {
+	for count := startCount; count < maxCountTry; count++ {
+		if chain != nil && count == checkpointCount {
+			chain.Checkpoints = chain.storeCheckpoint(chain.Checkpoints, tokenBase, ChainCheckpoint{Count: count, Code: uint64(currentCode)})
+		}
 		maskedToken, err := putBaseInToken(currentCode, tokenBase)
 		if err != nil {
-			return 0, 0, 0, err
+			return err
 		}
 		if maskedToken == token {
 			var thisType TokenType
@@ -70,31 +195,47 @@ func (d *TokenDecoder) GetActivationValueCountAndTypeFromToken(token int, starti
 			} else {
 				thisType = AddTime
 			}
-			if d.countIsValid(count, lastCount, value, thisType, usedCounts) {
-				return value, count, thisType, nil
+			if d.countIsValid(count, lastCount, value, thisType, used) {
+				*result = DecodeResult{
+					Value:      value,
+					Count:      count,
+					Type:       thisType,
+					Kind:       getTokenKind(value, thisType),
+					OlderToken: count <= lastCount,
+				}
+				return nil
+			} else if d.countIsInOlderWindow(count, lastCount, value) {
+				usedTokenFound = true
 			} else {
-				validOlderToken = true
+				outOfWindowTokenFound = true
 			}
 		}
 		currentCode = generateNextToken(currentCode, key) // If not we go to the next token
 	}
-	if validOlderToken {
-		return -2, 0, 0, nil
+	if usedTokenFound {
+		return &ErrValidOlderToken{}
+	}
+	if outOfWindowTokenFound {
+		return &ErrTokenOutOfWindow{}
 	}
-	return 0, 0, 0, &ErrInvalidToken{}
+	return &ErrInvalidToken{}
 }
 
 // Check if count is valid
-func (d *TokenDecoder) countIsValid(count int, lastCount int, value int, tokenType TokenType, usedCounts *[]int) bool {
+func (d *TokenDecoder) countIsValid(count int, lastCount int, value int, tokenType TokenType, used CountWindow) bool {
 	if value == CounterSyncValue {
-		if count > lastCount-30 {
-			return true
-		}
-	} else if count > lastCount {
+		return count > lastCount-d.counterSyncLookback
+	}
+	return d.activationCountIsValid(count, lastCount, tokenType, used)
+}
+
+// Check if count is valid for an activation token
+func (d *TokenDecoder) activationCountIsValid(count int, lastCount int, tokenType TokenType, used CountWindow) bool {
+	if count > lastCount {
 		return true
 	} else if d.maxUnusedOlderToken > 0 {
 		if count > lastCount-d.maxUnusedOlderToken {
-			if !slices.Contains(*usedCounts, count) && tokenType == AddTime {
+			if !used.Contains(count) && tokenType == AddTime {
 				return true
 			}
 		}
@@ -102,58 +243,123 @@ func (d *TokenDecoder) countIsValid(count int, lastCount int, value int, tokenTy
 	return false
 }
 
+// Check if count is in the window where older tokens can be accepted
+func (d *TokenDecoder) countIsInOlderWindow(count int, lastCount int, value int) bool {
+	if value == CounterSyncValue {
+		return count > lastCount-d.counterSyncLookback
+	}
+	return count > lastCount-d.maxUnusedOlderToken
+}
+
 // UpdateUsedCounts returns the list of used counts.
 func (d *TokenDecoder) UpdateUsedCounts(pastUsedCounts *[]int, value int, newCount int, tokenType TokenType) []int {
-	highestCount := 0
-	if pastUsedCounts != nil && len(*pastUsedCounts) > 0 {
-		highestCount = slices.Max(*pastUsedCounts)
-	}
-	if newCount > highestCount {
-		highestCount = newCount
-	}
-	bottomRange := highestCount - d.maxUnusedOlderToken
-	var usedCounts []int
-	if tokenType != AddTime || value == CounterSyncValue || value == PAYGDisableValue {
-		// If it isnot an Add TIme token, we mark al the past tokens as used in the range
-		for count := bottomRange; count <= highestCount; count++ {
-			usedCounts = append(usedCounts, count)
-		}
-	} else {
-		// If it is an Add Time token, we just mark the tokens actually used in the range
-		for count := bottomRange; count <= highestCount; count++ {
-			if count == newCount || slices.Contains(*pastUsedCounts, count) {
-				usedCounts = append(usedCounts, count)
-			}
-		}
+	used := windowFromSlice(pastUsedCounts)
+	d.UpdateCountWindow(&used, value, newCount, tokenType)
+	return used.Slice()
+}
+
+// UpdateExtendedUsedCounts returns the list of used counts after an extended token.
+// Extended tokens always add time, so only the count of the token is marked as used.
+func (d *TokenDecoder) UpdateExtendedUsedCounts(pastUsedCounts *[]int, newCount int) []int {
+	used := windowFromSlice(pastUsedCounts)
+	d.UpdateExtendedCountWindow(&used, newCount)
+	return used.Slice()
+}
+
+// UpdateCountWindow marks the count of a token as used, as UpdateUsedCounts does.
+func (d *TokenDecoder) UpdateCountWindow(used *CountWindow, value int, newCount int, tokenType TokenType) {
+	// If it is not an Add Time token, we mark all the past tokens as used in the range
+	markAllUsed := tokenType != AddTime || value == CounterSyncValue || value == PAYGDisableValue
+	used.Update(newCount, markAllUsed, d.maxUnusedOlderToken)
+}
+
+// UpdateExtendedCountWindow marks the count of an extended token as used, as UpdateExtendedUsedCounts does.
+func (d *TokenDecoder) UpdateExtendedCountWindow(used *CountWindow, newCount int) {
+	used.Update(newCount, false, d.maxUnusedOlderToken)
+}
+
+// windowFromSlice returns the window of a list of used counts, which can be nil.
+func windowFromSlice(usedCounts *[]int) CountWindow {
+	if usedCounts == nil {
+		return CountWindow{}
 	}
-	return usedCounts
+	return CountWindowFromSlice(*usedCounts)
 }
 
-// GetActivationValueCountAndTypeFromExtendedToken returns the value, count and type of the token.
-// If the token is not valid, an error is returned.
+// GetActivationValueCountAndTypeFromExtendedToken returns the value and count of the token.
+// If the token is not valid or was already used, an error is returned as for DecodeToken.
+// A 20 digits restricted token does not fit in an int, use Decode with ParseToken for those.
+//
+// Deprecated: Use Decode instead.
 func (d *TokenDecoder) GetActivationValueCountAndTypeFromExtendedToken(token int, startingCode int, key *[16]byte, lastCount int, restrictedDigitSet bool, usedCounts *[]int) (int, int, error) {
+	code := uint64(token)
 	if restrictedDigitSet {
-		token = int(convertFrom4DigitToken(token))
+		var err error
+		code, err = convertFrom4DigitToken(uint64(token))
+		if err != nil {
+			return 0, 0, err
+		}
 	}
-	tokenBase := getTokenBaseExtended(token)                            // We get the base of the token
-	currentCode, err := putBaseInTokenExtended(startingCode, tokenBase) // We put the base in the starting code
-	if err != nil {
+	var result DecodeResult
+	if err := d.decodeExtendedCode(&result, code, startingCode, key, lastCount, windowFromSlice(usedCounts), nil); err != nil {
 		return 0, 0, err
 	}
-	startingCodeBase := getTokenBaseExtended(startingCode)   // We get the base of the starting code
-	value := decodeBaseExtended(startingCodeBase, tokenBase) // If there is a match we get the value from the token
-	for count := 0; count < 30; count++ {
+	return result.Value, result.Count, nil
+}
+
+// decodeExtendedCode decodes an extended token code into the result.
+// Extended tokens always add time, they follow the same windows as the standard add time tokens.
+// If a chain is given, the search starts from its checkpoint and a new checkpoint is stored in it.
+func (d *TokenDecoder) decodeExtendedCode(result *DecodeResult, token uint64, startingCode int, key *[16]byte, lastCount int, used CountWindow, chain *TokenChain) error {
+	usedTokenFound := false
+	outOfWindowTokenFound := false
+	tokenBase := getTokenBaseExtended(token)                                    // We get the base of the token
+	currentCode, err := putBaseInTokenExtended(uint64(startingCode), tokenBase) // We put the base in the starting code
+	if err != nil {
+		return err
+	}
+	startingCodeBase := getTokenBaseExtended(uint64(startingCode)) // We get the base of the starting code
+	value := decodeBaseExtended(startingCodeBase, tokenBase)       // If there is a match we get the value from the token
+	startCount, checkpointCount := 0, d.chainCheckpointCount(lastCount)
+	if chain != nil {
+		if checkpoint, ok := chain.ExtendedCheckpoints[tokenBase]; ok && checkpoint.Count <= checkpointCount {
+			startCount, currentCode = checkpoint.Count, checkpoint.Code
+		}
+	}
+	maxCountTry := lastCount + d.maxTokenJump + 1
+	for count := startCount; count < maxCountTry; count++ {
+		if chain != nil && count == checkpointCount {
+			chain.ExtendedCheckpoints = chain.storeCheckpoint(chain.ExtendedCheckpoints, tokenBase, ChainCheckpoint{Count: count, Code: currentCode})
+		}
 		maskedToken, err := putBaseInTokenExtended(currentCode, tokenBase)
 		if err != nil {
-			return 0, 0, err
+			return err
 		}
-		if maskedToken == token && count > lastCount {
-			cleanCount := count - 1
-			return value, cleanCount, nil
+		if maskedToken == token {
+			if d.activationCountIsValid(count, lastCount, AddTime, used) {
+				*result = DecodeResult{
+					Value:      value,
+					Count:      count,
+					Type:       AddTime,
+					Kind:       KindAddTime,
+					OlderToken: count <= lastCount,
+				}
+				return nil
+			} else if count > lastCount-d.maxUnusedOlderToken {
+				usedTokenFound = true
+			} else {
+				outOfWindowTokenFound = true
+			}
 		}
 		currentCode = generateNextTokenExtended(currentCode, key) // If not we go to the next token
 	}
-	return 0, 0, fmt.Errorf("token not found")
+	if usedTokenFound {
+		return &ErrValidOlderToken{}
+	}
+	if outOfWindowTokenFound {
+		return &ErrTokenOutOfWindow{}
+	}
+	return &ErrInvalidToken{}
 }
 
 // Get decode base
@@ -174,15 +380,27 @@ func decodeBaseExtended(startingCodeBase int, tokenBase int) int {
 	}
 }
 
-// Convert token for restricted digit set
-func convertFrom4DigitToken(token int) int64 {
-	var decoded int64 = 0
-	for _, digit := range fmt.Sprintf("%d", token) {
-		decoded = decoded*10 + (int64(digit-'0') - 1)
+// Convert token from restricted digit set
+// Each decimal digit from 1 to 4 of the token is a base 4 digit of the code.
+func convertFrom4DigitToken(token uint64) (uint64, error) {
+	length := 1
+	for rest := token / 10; rest > 0; rest /= 10 {
+		length++
+	}
+	var decoded uint64
+	var err error
+	place := uint64(1)
+	for position := length - 1; position >= 0; position-- {
+		digit := byte(token%10) + '0'
+		if digit < '1' || digit > '4' {
+			err = &ErrInvalidRestrictedDigit{Digit: digit, Position: position} // We keep the leftmost invalid digit
+		}
+		decoded += uint64(digit-'1') * place
+		place *= 4
+		token /= 10
 	}
-	decoded, err := strconv.ParseInt(fmt.Sprintf("%d", decoded), 4, 64)
 	if err != nil {
-		return 0
+		return 0, err
 	}
-	return decoded
+	return decoded, nil
 }
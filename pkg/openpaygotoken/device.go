@@ -0,0 +1,250 @@
+package openpaygotoken
+
+import (
+	"errors"
+	"time"
+)
+
+// DeviceState is the state a device keeps between two tokens.
+type DeviceState struct {
+	Count                  int         `json:"count"`
+	UsedCounts             CountWindow `json:"used_counts"`
+	ExtendedCount          int         `json:"extended_count"`
+	ExtendedUsedCounts     CountWindow `json:"extended_used_counts"`
+	PaygEnabled            bool        `json:"payg_enabled"`
+	ExpirationTimestamp    time.Time   `json:"expiration_timestamp"`
+	InvalidTokenCount      int         `json:"invalid_token_count"`
+	FirstInvalidTokenAt    time.Time   `json:"first_invalid_token_at"` // The start of the day counted by DailyAttemptsLockout
+	TokenEntryBlockedUntil time.Time   `json:"token_entry_blocked_until"`
+}
+
+// Device applies tokens to a device state.
+type Device struct {
+	StartingCode         int
+	Key                  [16]byte
+	TimeDivider          int
+	RestrictedDigitSet   bool
+	WaitingPeriodEnabled bool
+	Chain                *TokenChain // Optional checkpoints to start the token search near the last count, not saved with the state
+	Clock                Clock
+	Lockout              LockoutPolicy // How long the token entry is blocked after invalid tokens, EscalatingLockout if nil
+	Storage              Storage       // Optional non volatile memory the state is saved to, see Recover
+	DeviceState
+	decoder        *TokenDecoder
+	sequence       uint32
+	listeners      []deviceListener
+	lastListenerID int
+}
+
+// TokenResult is the result of a token applied to a device.
+type TokenResult struct {
+	DecodeResult
+	PaygEnabled         bool
+	ExpirationTimestamp time.Time
+}
+
+// NewDevice creates a new device with a decoder configured by the given options.
+func NewDevice(startingCode int, key *[16]byte, startingCount int, restrictedDigitSet bool, waitingPeriodEnabled bool, timeDivider int, options ...DecoderOption) (*Device, error) {
+	return NewDeviceWithClock(RealClock{}, startingCode, key, startingCount, restrictedDigitSet, waitingPeriodEnabled, timeDivider, options...)
+}
+
+// NewDeviceWithClock creates a new device reading the time from the given clock.
+// The time divider is the value of a day of activation and must be at least 1.
+func NewDeviceWithClock(clock Clock, startingCode int, key *[16]byte, startingCount int, restrictedDigitSet bool, waitingPeriodEnabled bool, timeDivider int, options ...DecoderOption) (*Device, error) {
+	if timeDivider < 1 {
+		return nil, &ErrInvalidTimeDivider{TimeDivider: timeDivider}
+	}
+	decoder, err := NewDecoder(options...)
+	if err != nil {
+		return nil, err
+	}
+	now := NowFrom(clock)
+	return &Device{
+		StartingCode:         startingCode,
+		Key:                  *key,
+		TimeDivider:          timeDivider,
+		RestrictedDigitSet:   restrictedDigitSet,
+		WaitingPeriodEnabled: waitingPeriodEnabled,
+		DeviceState: DeviceState{
+			Count:                  startingCount,
+			PaygEnabled:            true,
+			ExpirationTimestamp:    now,
+			TokenEntryBlockedUntil: now,
+		},
+		Clock:   clock,
+		Lockout: EscalatingLockout{},
+		decoder: decoder,
+	}, nil
+}
+
+// ApplyToken decodes the token and updates the device state with it.
+// If the token entry is blocked, the token is invalid or already used, an error is returned and the
+// activation is left unchanged. Only invalid tokens count towards the token entry blocking.
+func (d *Device) ApplyToken(token int) (*TokenResult, error) {
+	digits := formatInt(token)
+	if d.RemainingWait() > 0 {
+		return d.reject(digits, &ErrTokenEntryBlocked{})
+	}
+	if d.RestrictedDigitSet {
+		code, err := convertFrom4DigitToken(uint64(token))
+		if err != nil {
+			return d.reject(digits, err)
+		}
+		token = int(code)
+	}
+	return d.apply(&Token{Digits: digits, Code: uint64(token), Format: StandardFormat})
+}
+
+// EnterToken parses a token entered by a user and updates the device state with it.
+// The token can be a standard or an extended token using the digit set of the device.
+// Extended tokens have their own count, separate from the count of standard tokens.
+func (d *Device) EnterToken(input string) (*TokenResult, error) {
+	token, err := ParseToken(input)
+	if err != nil {
+		return d.reject(input, err)
+	}
+	if token.IsRestricted() != d.RestrictedDigitSet {
+		return d.reject(token.Digits, &ErrUnexpectedTokenFormat{Format: token.Format})
+	}
+	if d.RemainingWait() > 0 {
+		return d.reject(token.Digits, &ErrTokenEntryBlocked{})
+	}
+	return d.apply(token)
+}
+
+// apply decodes a token and updates the device state with it.
+// With a storage, the token is written to the journal before the state is changed, and the journal is emptied once
+// the new state is saved. If the storage fails after the journal is written, Recover applies the token on next boot.
+func (d *Device) apply(token *Token) (*TokenResult, error) {
+	var result *DecodeResult
+	var err error
+	lastCount, used := d.Count, d.UsedCounts
+	if token.IsExtended() {
+		lastCount, used = d.ExtendedCount, d.ExtendedUsedCounts
+	}
+	if d.Chain != nil {
+		result, err = d.decoder.DecodeWindowWithChain(token, d.Chain, lastCount, used)
+	} else {
+		result, err = d.decoder.DecodeWindow(token, d.StartingCode, &d.Key, lastCount, used)
+	}
+	if errors.Is(err, &ErrValidOlderToken{}) {
+		d.emit(DeviceEvent{Kind: EventTokenAlreadyUsed, Token: token.Digits, Err: err})
+		return nil, err
+	}
+	if err != nil {
+		d.registerInvalidToken()
+		if saveErr := d.save(); saveErr != nil {
+			return nil, saveErr
+		}
+		d.reject(token.Digits, err)
+		if wait := d.RemainingWait(); wait > 0 {
+			d.emit(DeviceEvent{Kind: EventLockout, Token: token.Digits, Wait: wait})
+		}
+		return nil, err
+	}
+	now := d.now()
+	if err = d.writeJournal(token.IsExtended(), result, now); err != nil {
+		return nil, err
+	}
+	d.commit(token.IsExtended(), result, now)
+	if err = d.save(); err != nil {
+		return nil, err
+	}
+	if err = d.clearJournal(); err != nil {
+		return nil, err
+	}
+	event := DeviceEvent{
+		Kind:                EventTokenAccepted,
+		Token:               token.Digits,
+		Count:               result.Count,
+		Value:               result.Value,
+		ExpirationTimestamp: d.ExpirationTimestamp,
+	}
+	d.emit(event)
+	if result.Kind == KindDisable {
+		event.Kind = EventPaygDisabled
+		d.emit(event)
+	}
+	return &TokenResult{
+		DecodeResult:        *result,
+		PaygEnabled:         d.PaygEnabled,
+		ExpirationTimestamp: d.ExpirationTimestamp,
+	}, nil
+}
+
+// IsActive returns true if the device is active: its activation has not expired, or PAYG is disabled.
+// A device with PAYG disabled stays active whatever its expiration, until a set time token enables PAYG again.
+func (d *Device) IsActive() bool {
+	return !d.PaygEnabled || d.now().Before(d.ExpirationTimestamp)
+}
+
+// RemainingWait returns how long the token entry is still blocked, 0 if it is not or the waiting period is disabled.
+func (d *Device) RemainingWait() time.Duration {
+	if !d.WaitingPeriodEnabled {
+		return 0
+	}
+	return d.lockout().RemainingWait(&d.DeviceState, d.now())
+}
+
+// registerInvalidToken counts the invalid token in the lockout policy of the device.
+func (d *Device) registerInvalidToken() {
+	d.lockout().RegisterInvalidToken(&d.DeviceState, d.now())
+}
+
+// lockout returns the lockout policy of the device.
+func (d *Device) lockout() LockoutPolicy {
+	if d.Lockout == nil {
+		return EscalatingLockout{}
+	}
+	return d.Lockout
+}
+
+// commit updates the device state from a valid token accepted at the given time.
+func (d *Device) commit(extended bool, result *DecodeResult, now time.Time) {
+	if extended {
+		d.applyExtendedValue(result.Value, result.Count)
+	} else {
+		d.applyValue(result.Value, result.Count, result.Type, now)
+	}
+}
+
+// applyValue updates the count, the used counts and the activation from a valid token.
+func (d *Device) applyValue(value int, count int, tokenType TokenType, now time.Time) {
+	if count > d.Count || value == CounterSyncValue {
+		d.Count = count
+	}
+	d.decoder.UpdateCountWindow(&d.UsedCounts, value, count, tokenType)
+	d.InvalidTokenCount = 0
+	if value <= MaxActivationValue {
+		if !d.PaygEnabled && tokenType == SetTime {
+			d.PaygEnabled = true
+		}
+		if d.PaygEnabled {
+			activation := time.Duration(value/d.TimeDivider) * 24 * time.Hour
+			if tokenType == SetTime {
+				d.ExpirationTimestamp = now.Add(activation)
+			} else {
+				d.ExpirationTimestamp = d.ExpirationTimestamp.Add(activation)
+			}
+		}
+	} else if value == PAYGDisableValue {
+		d.PaygEnabled = false
+	}
+}
+
+// applyExtendedValue updates the extended count, the extended used counts and the activation from a valid extended token.
+func (d *Device) applyExtendedValue(value int, count int) {
+	if count > d.ExtendedCount {
+		d.ExtendedCount = count
+	}
+	d.decoder.UpdateExtendedCountWindow(&d.ExtendedUsedCounts, count)
+	d.InvalidTokenCount = 0
+	if d.PaygEnabled {
+		d.ExpirationTimestamp = d.ExpirationTimestamp.Add(time.Duration(value/d.TimeDivider) * 24 * time.Hour)
+	}
+}
+
+// now returns the time of the clock of the device.
+func (d *Device) now() time.Time {
+	return NowFrom(d.Clock)
+}
@@ -0,0 +1,210 @@
+package openpaygotoken
+
+// DiagnosisReason is the rule that accepted or rejected a diagnosed token.
+type DiagnosisReason int
+
+const (
+	// ReasonAccepted is the reason of a token accepted by the decoder.
+	ReasonAccepted DiagnosisReason = 1
+	// ReasonMalformed is the reason of a token with invalid characters, digits or length.
+	ReasonMalformed DiagnosisReason = 2
+	// ReasonNoMatch is the reason of a token no count generates: the key or the starting code is wrong, or the token
+	// was mistyped.
+	ReasonNoMatch DiagnosisReason = 3
+	// ReasonTooFarAhead is the reason of a token more than MaxTokenJump above the last count, or
+	// MaxTokenJumpCounterSync for a counter sync token.
+	ReasonTooFarAhead DiagnosisReason = 4
+	// ReasonAlreadyUsed is the reason of an older add time token whose count is marked as used.
+	ReasonAlreadyUsed DiagnosisReason = 5
+	// ReasonOlderSetTime is the reason of an unused older token that does not add time, only add time tokens can be
+	// entered out of order.
+	ReasonOlderSetTime DiagnosisReason = 6
+	// ReasonOlderTokensDisabled is the reason of an older token when MaxUnusedOlderToken is 0.
+	ReasonOlderTokensDisabled DiagnosisReason = 7
+	// ReasonOlderThanWindow is the reason of a token MaxUnusedOlderToken or more below the last count.
+	ReasonOlderThanWindow DiagnosisReason = 8
+	// ReasonCounterSyncTooOld is the reason of a counter sync token CounterSyncLookback or more below the last count.
+	ReasonCounterSyncTooOld DiagnosisReason = 9
+)
+
+// String returns the name of the diagnosis reason.
+func (r DiagnosisReason) String() string {
+	switch r {
+	case ReasonAccepted:
+		return "Accepted"
+	case ReasonMalformed:
+		return "Malformed"
+	case ReasonNoMatch:
+		return "NoMatch"
+	case ReasonTooFarAhead:
+		return "TooFarAhead"
+	case ReasonAlreadyUsed:
+		return "AlreadyUsed"
+	case ReasonOlderSetTime:
+		return "OlderSetTime"
+	case ReasonOlderTokensDisabled:
+		return "OlderTokensDisabled"
+	case ReasonOlderThanWindow:
+		return "OlderThanWindow"
+	case ReasonCounterSyncTooOld:
+		return "CounterSyncTooOld"
+	default:
+		return "Unknown"
+	}
+}
+
+// TokenMatch is a count at which the key and starting code generate a token.
+type TokenMatch struct {
+	Count int
+	Value int
+	Type  TokenType
+	Kind  TokenKind
+	Used  bool // The count is marked as used
+}
+
+// Diagnosis explains why a decoder accepts or rejects a token.
+type Diagnosis struct {
+	Token     *Token // The parsed token, nil if it is malformed
+	Reason    DiagnosisReason
+	Match     *TokenMatch // The match accepted by the decoder, or else the closest to the last count, nil if none
+	LastCount int
+	ScanLimit int           // The highest count searched for a match
+	Config    DecoderConfig // The windows of the decoder
+	Err       error         // The error returned by the decoder, nil if the token is accepted
+}
+
+// Diagnose decodes a token entered by a user as DecodeDigits does and explains the result.
+// If the token is rejected, the counts are searched far beyond the decoder windows for the match closest to the
+// last count, and the rule rejecting it is reported.
+func (d *TokenDecoder) Diagnose(input string, startingCode int, key *[16]byte, lastCount int, used CountWindow) *Diagnosis {
+	diagnosis := &Diagnosis{LastCount: lastCount, ScanLimit: lastCount + maxDecoderWindow, Config: d.Config()}
+	token, err := ParseToken(input)
+	if err != nil {
+		diagnosis.Reason, diagnosis.Err = ReasonMalformed, err
+		return diagnosis
+	}
+	diagnosis.Token = token
+	result, err := d.DecodeWindow(token, startingCode, key, lastCount, used)
+	if err == nil {
+		diagnosis.Reason = ReasonAccepted
+		diagnosis.Match = &TokenMatch{Count: result.Count, Value: result.Value, Type: result.Type, Kind: result.Kind, Used: used.Contains(result.Count)}
+		return diagnosis
+	}
+	diagnosis.Err = err
+	match, ok := d.closestMatch(token, startingCode, key, lastCount, diagnosis.ScanLimit)
+	if !ok {
+		diagnosis.Reason = ReasonNoMatch
+		return diagnosis
+	}
+	match.Used = used.Contains(match.Count)
+	diagnosis.Match = &match
+	diagnosis.Reason = d.rejection(match, lastCount, token.IsExtended())
+	return diagnosis
+}
+
+// Explain returns a sentence explaining the diagnosis to a support agent.
+func (d *Diagnosis) Explain() string {
+	switch d.Reason {
+	case ReasonAccepted:
+		return "The token is valid: count " + formatInt(d.Match.Count) + ", value " + formatInt(d.Match.Value) + ", kind " + d.Match.Kind.String()
+	case ReasonMalformed:
+		return "The token is malformed: " + d.Err.Error()
+	case ReasonNoMatch:
+		return "No count up to " + formatInt(d.ScanLimit) + " generates the token: the key or the starting code is wrong, or the token was mistyped"
+	case ReasonTooFarAhead:
+		maxJump := d.Config.MaxTokenJump
+		if d.Match.Kind == KindCounterSync {
+			maxJump = d.Config.MaxTokenJumpCounterSync
+		}
+		return "The token count " + formatInt(d.Match.Count) + " is " + formatInt(d.Match.Count-d.LastCount) + " above the last count " + formatInt(d.LastCount) +
+			", more than the maximum jump of " + formatInt(maxJump) + ": the device missed tokens or its count must be synchronised"
+	case ReasonAlreadyUsed:
+		return "The token count " + formatInt(d.Match.Count) + " was already used"
+	case ReasonOlderSetTime:
+		return "The " + d.Match.Kind.String() + " token count " + formatInt(d.Match.Count) + " is not above the last count " + formatInt(d.LastCount) +
+			", only add time tokens can be entered out of order"
+	case ReasonOlderTokensDisabled:
+		return "The token count " + formatInt(d.Match.Count) + " is not above the last count " + formatInt(d.LastCount) + " and older tokens are disabled"
+	case ReasonOlderThanWindow:
+		return "The token count " + formatInt(d.Match.Count) + " is " + formatInt(d.LastCount-d.Match.Count) + " below the last count " + formatInt(d.LastCount) +
+			", older tokens are only accepted less than " + formatInt(d.Config.MaxUnusedOlderToken) + " below"
+	case ReasonCounterSyncTooOld:
+		return "The counter sync token count " + formatInt(d.Match.Count) + " is " + formatInt(d.LastCount-d.Match.Count) + " below the last count " + formatInt(d.LastCount) +
+			", counter sync tokens are only accepted less than " + formatInt(d.Config.CounterSyncLookback) + " below"
+	default:
+		return "Unknown diagnosis"
+	}
+}
+
+// closestMatch returns the match of a token closest to the last count, searching the counts up to the limit.
+func (d *TokenDecoder) closestMatch(token *Token, startingCode int, key *[16]byte, lastCount int, limit int) (TokenMatch, bool) {
+	var match TokenMatch
+	found := false
+	closer := func(count int) bool {
+		return !found || distance(count, lastCount) <= distance(match.Count, lastCount)
+	}
+	if token.IsExtended() {
+		tokenBase := getTokenBaseExtended(token.Code)
+		currentCode, err := putBaseInTokenExtended(uint64(startingCode), tokenBase)
+		if err != nil {
+			return match, false
+		}
+		value := decodeBaseExtended(getTokenBaseExtended(uint64(startingCode)), tokenBase)
+		for count := 0; count <= limit; count++ {
+			if maskedToken, _ := putBaseInTokenExtended(currentCode, tokenBase); maskedToken == token.Code && closer(count) {
+				match, found = TokenMatch{Count: count, Value: value, Type: AddTime, Kind: KindAddTime}, true
+			}
+			currentCode = generateNextTokenExtended(currentCode, key)
+		}
+		return match, found
+	}
+	tokenBase := getTokenBase(int(token.Code))
+	currentCode, err := putBaseInToken(startingCode, tokenBase)
+	if err != nil {
+		return match, false
+	}
+	value := decodeBase(getTokenBase(startingCode), tokenBase)
+	for count := 0; count <= limit; count++ {
+		if maskedToken, _ := putBaseInToken(currentCode, tokenBase); maskedToken == int(token.Code) && closer(count) {
+			// We use the same parity rule as the decoder for the token type
+			tokenType := AddTime
+			if count%2 == 1 {
+				tokenType = SetTime
+			}
+			match, found = TokenMatch{Count: count, Value: value, Type: tokenType, Kind: getTokenKind(value, tokenType)}, true
+		}
+		currentCode = generateNextToken(currentCode, key)
+	}
+	return match, found
+}
+
+// rejection returns the rule of countIsValid rejecting a match of a token the decoder did not accept.
+func (d *TokenDecoder) rejection(match TokenMatch, lastCount int, extended bool) DiagnosisReason {
+	counterSync := !extended && match.Value == CounterSyncValue
+	maxJump := d.maxTokenJump
+	if counterSync {
+		maxJump = d.maxTokenJumpCounterSync
+	}
+	switch {
+	case match.Count > lastCount+maxJump:
+		return ReasonTooFarAhead
+	case counterSync:
+		return ReasonCounterSyncTooOld
+	case d.maxUnusedOlderToken == 0:
+		return ReasonOlderTokensDisabled
+	case match.Count <= lastCount-d.maxUnusedOlderToken:
+		return ReasonOlderThanWindow
+	case match.Used:
+		return ReasonAlreadyUsed
+	default:
+		return ReasonOlderSetTime
+	}
+}
+
+// distance returns the absolute difference of two counts.
+func distance(count int, otherCount int) int {
+	if count < otherCount {
+		return otherCount - count
+	}
+	return count - otherCount
+}
@@ -1,10 +1,5 @@
 package openpaygotoken
 
-import (
-	"fmt"
-	"strconv"
-)
-
 // GenerateStandardToken generates a token with the given parameters.
 // The token is generated from the starting code, the key, the value, the count and the mode.
 // This function returns the count, the token and an error if there is one.
@@ -16,21 +11,7 @@ func GenerateStandardToken(startingCode int, key *[16]byte, value int, count int
 	if err != nil {
 		return 0, "", err
 	}
-	currentCountOdd := count%2 == 1
-	var newCount int
-	if mode == SetTime {
-		if currentCountOdd { // Odd numbers are for SetTime
-			newCount = count + 2
-		} else {
-			newCount = count + 1
-		}
-	} else {
-		if currentCountOdd { // Even numbers are for AddTime
-			newCount = count + 1
-		} else {
-			newCount = count + 2
-		}
-	}
+	newCount := getNewCount(count, mode)
 	for xn := 0; xn < newCount; xn++ {
 		currentToken = generateNextToken(currentToken, key)
 	}
@@ -38,21 +19,16 @@ func GenerateStandardToken(startingCode int, key *[16]byte, value int, count int
 	if err != nil {
 		return 0, "", err
 	}
-	if restrictedDigitSet {
-		finalToken = convertTo4DigitToken(finalToken)
-		return newCount, fmt.Sprintf("%015d", finalToken), nil
-	} else {
-		return newCount, fmt.Sprintf("%09d", finalToken), nil
-	}
+	return newCount, formatStandardToken(finalToken, restrictedDigitSet), nil
 }
 
 // GenerateExtendedToken generates a token with the given parameters.
 // The token is generated from the starting code, the key, the value, the count and the mode.
 // This function returns the count, the token and an error if there is one.
 func GenerateExtendedToken(startingCode int, key *[16]byte, value int, count int, restrictedDigitSet bool) (int, string, error) {
-	startingCodeBase := getTokenBaseExtended(startingCode)
+	startingCodeBase := getTokenBaseExtended(uint64(startingCode))
 	tokenBase := encodeBaseExtended(startingCodeBase, value)
-	currentToken, err := putBaseInTokenExtended(startingCode, tokenBase)
+	currentToken, err := putBaseInTokenExtended(uint64(startingCode), tokenBase)
 	if err != nil {
 		return 0, "", err
 	}
@@ -64,11 +40,76 @@ func GenerateExtendedToken(startingCode int, key *[16]byte, value int, count int
 	if err != nil {
 		return 0, "", err
 	}
+	return newCount, formatExtendedToken(finalToken, restrictedDigitSet), nil
+}
+
+// SplitActivationValue returns the values of the tokens activating a value of any size, in the order to enter them.
+// The device turns each token into days separately, so every value but the last is a multiple of the time divider
+// and no fraction of a day is lost. A zero value gives a single token of value 0.
+func SplitActivationValue(value int, timeDivider int) []int {
+	maxValue := maxSplitValue(timeDivider)
+	values := make([]int, 0, ActivationTokenCount(value, timeDivider))
+	for len(values) == 0 || value > 0 {
+		tokenValue := value
+		if tokenValue > maxValue {
+			tokenValue = maxValue
+		}
+		values = append(values, tokenValue)
+		value -= tokenValue
+	}
+	return values
+}
+
+// ActivationTokenCount returns the number of tokens SplitActivationValue splits a value in.
+func ActivationTokenCount(value int, timeDivider int) int {
+	if value <= 0 {
+		return 1
+	}
+	maxValue := maxSplitValue(timeDivider)
+	return (value + maxValue - 1) / maxValue
+}
+
+// maxSplitValue returns the largest multiple of the time divider that fits in a token.
+func maxSplitValue(timeDivider int) int {
+	if timeDivider < 1 || timeDivider > MaxActivationValue {
+		return MaxActivationValue
+	}
+	return MaxActivationValue - MaxActivationValue%timeDivider
+}
+
+// Get the count of the next token of the given mode
+func getNewCount(count int, mode TokenType) int {
+	currentCountOdd := count%2 == 1
+	if mode == SetTime {
+		if currentCountOdd { // Odd numbers are for SetTime
+			return count + 2
+		} else {
+			return count + 1
+		}
+	} else {
+		if currentCountOdd { // Even numbers are for AddTime
+			return count + 1
+		} else {
+			return count + 2
+		}
+	}
+}
+
+// Format standard token
+func formatStandardToken(token int, restrictedDigitSet bool) string {
 	if restrictedDigitSet {
-		finalToken = convertTo4DigitToken(finalToken)
-		return newCount, fmt.Sprintf("%020d", finalToken), nil
+		return convertTo4DigitToken(uint64(token), restrictedStandardTokenLength)
 	} else {
-		return newCount, fmt.Sprintf("%012d", finalToken), nil
+		return formatUint(uint64(token), standardTokenLength)
+	}
+}
+
+// Format extended token
+func formatExtendedToken(token uint64, restrictedDigitSet bool) string {
+	if restrictedDigitSet {
+		return convertTo4DigitToken(token, restrictedExtendedTokenLength)
+	} else {
+		return formatUint(token, extendedTokenLength)
 	}
 }
 
@@ -91,11 +132,12 @@ func encodeBaseExtended(base int, number int) int {
 }
 
 // Convert token to restricted digit set
-func convertTo4DigitToken(token int) int {
-	encoded := 0
-	for _, digit := range strconv.FormatInt(int64(token), 4) {
-		encoded = encoded*10 + int(digit-'0') + 1
+// Each base 4 digit of the token is shifted to the digits 1 to 4, padded to the given length.
+func convertTo4DigitToken(token uint64, length int) string {
+	encoded := make([]byte, length)
+	for position := length - 1; position >= 0; position-- {
+		encoded[position] = byte(token%4) + '1'
+		token /= 4
 	}
-
-	return encoded
+	return string(encoded)
 }
@@ -1,17 +1,20 @@
 package openpaygotoken
 
-import "fmt"
-
 // ErrInvalidTokenBase is returned when the token base is invalid.
 type ErrInvalidTokenBase struct {
 	Value int
 }
 
 func (e *ErrInvalidTokenBase) Error() string {
-	return fmt.Sprintf("Invalid token base %d", e.Value)
+	return "Invalid token base " + formatInt(e.Value)
+}
+
+func (e *ErrInvalidTokenBase) Is(target error) bool {
+	_, ok := target.(*ErrInvalidTokenBase)
+	return ok
 }
 
-// ErrInvalidTokenValue is returned when the token value is invalid.
+// ErrValidOlderToken is returned when the token is valid but was already used.
 type ErrValidOlderToken struct {
 }
 
@@ -19,6 +22,28 @@ func (e *ErrValidOlderToken) Error() string {
 	return "Valid older token"
 }
 
+func (e *ErrValidOlderToken) Is(target error) bool {
+	_, ok := target.(*ErrValidOlderToken)
+	return ok
+}
+
+// ErrTokenOutOfWindow is returned when the token is valid but older than the window of accepted older tokens.
+// It also matches ErrValidOlderToken.
+type ErrTokenOutOfWindow struct {
+}
+
+func (e *ErrTokenOutOfWindow) Error() string {
+	return "Token out of window"
+}
+
+func (e *ErrTokenOutOfWindow) Is(target error) bool {
+	switch target.(type) {
+	case *ErrTokenOutOfWindow, *ErrValidOlderToken:
+		return true
+	}
+	return false
+}
+
 // ErrInvalidToken is returned when the token is invalid.
 type ErrInvalidToken struct {
 }
@@ -26,3 +51,189 @@ type ErrInvalidToken struct {
 func (e *ErrInvalidToken) Error() string {
 	return "Invalid token"
 }
+
+func (e *ErrInvalidToken) Is(target error) bool {
+	_, ok := target.(*ErrInvalidToken)
+	return ok
+}
+
+// ErrTokenEntryBlocked is returned when the token entry is blocked.
+type ErrTokenEntryBlocked struct {
+}
+
+func (e *ErrTokenEntryBlocked) Error() string {
+	return "Token entry blocked"
+}
+
+func (e *ErrTokenEntryBlocked) Is(target error) bool {
+	_, ok := target.(*ErrTokenEntryBlocked)
+	return ok
+}
+
+// ErrEmptyToken is returned when the token has no digits.
+type ErrEmptyToken struct {
+}
+
+func (e *ErrEmptyToken) Error() string {
+	return "Empty token"
+}
+
+func (e *ErrEmptyToken) Is(target error) bool {
+	_, ok := target.(*ErrEmptyToken)
+	return ok
+}
+
+// ErrInvalidTokenCharacter is returned when the token contains a character that is not a digit or a separator.
+type ErrInvalidTokenCharacter struct {
+	Char     byte
+	Position int
+}
+
+func (e *ErrInvalidTokenCharacter) Error() string {
+	return "Invalid token character " + quoteByte(e.Char) + " at position " + formatInt(e.Position)
+}
+
+func (e *ErrInvalidTokenCharacter) Is(target error) bool {
+	_, ok := target.(*ErrInvalidTokenCharacter)
+	return ok
+}
+
+// ErrInvalidTokenLength is returned when the number of digits does not match any token format.
+type ErrInvalidTokenLength struct {
+	Length int
+}
+
+func (e *ErrInvalidTokenLength) Error() string {
+	return "Invalid token length " + formatInt(e.Length)
+}
+
+func (e *ErrInvalidTokenLength) Is(target error) bool {
+	_, ok := target.(*ErrInvalidTokenLength)
+	return ok
+}
+
+// ErrInvalidRestrictedDigit is returned when a restricted digit set token contains a digit outside of 1 to 4.
+type ErrInvalidRestrictedDigit struct {
+	Digit    byte
+	Position int
+}
+
+func (e *ErrInvalidRestrictedDigit) Error() string {
+	return "Invalid restricted digit " + quoteByte(e.Digit) + " at position " + formatInt(e.Position)
+}
+
+func (e *ErrInvalidRestrictedDigit) Is(target error) bool {
+	_, ok := target.(*ErrInvalidRestrictedDigit)
+	return ok
+}
+
+// ErrUnexpectedTokenFormat is returned when the token format is not supported by the device.
+type ErrUnexpectedTokenFormat struct {
+	Format TokenFormat
+}
+
+func (e *ErrUnexpectedTokenFormat) Error() string {
+	return "Unexpected token format " + e.Format.String()
+}
+
+func (e *ErrUnexpectedTokenFormat) Is(target error) bool {
+	_, ok := target.(*ErrUnexpectedTokenFormat)
+	return ok
+}
+
+// ErrInvalidDecoderConfig is returned when a value of the decoder configuration is out of range.
+type ErrInvalidDecoderConfig struct {
+	Field string
+	Value int
+}
+
+func (e *ErrInvalidDecoderConfig) Error() string {
+	return "Invalid decoder configuration " + e.Field + " " + formatInt(e.Value)
+}
+
+func (e *ErrInvalidDecoderConfig) Is(target error) bool {
+	_, ok := target.(*ErrInvalidDecoderConfig)
+	return ok
+}
+
+// ErrInvalidTimeDivider is returned when a device is created with a time divider below 1.
+type ErrInvalidTimeDivider struct {
+	TimeDivider int
+}
+
+func (e *ErrInvalidTimeDivider) Error() string {
+	return "Invalid time divider " + formatInt(e.TimeDivider)
+}
+
+func (e *ErrInvalidTimeDivider) Is(target error) bool {
+	_, ok := target.(*ErrInvalidTimeDivider)
+	return ok
+}
+
+// ErrInvalidCountWindow is returned when the used counts read from JSON are not a list of integers.
+type ErrInvalidCountWindow struct {
+}
+
+func (e *ErrInvalidCountWindow) Error() string {
+	return "Invalid used counts"
+}
+
+func (e *ErrInvalidCountWindow) Is(target error) bool {
+	_, ok := target.(*ErrInvalidCountWindow)
+	return ok
+}
+
+// ErrCorruptState is returned when a saved device state is truncated or does not match its checksum.
+type ErrCorruptState struct {
+}
+
+func (e *ErrCorruptState) Error() string {
+	return "Corrupt device state"
+}
+
+func (e *ErrCorruptState) Is(target error) bool {
+	_, ok := target.(*ErrCorruptState)
+	return ok
+}
+
+// ErrUnsupportedStateVersion is returned when a saved device state has an unknown version.
+type ErrUnsupportedStateVersion struct {
+	Version byte
+}
+
+func (e *ErrUnsupportedStateVersion) Error() string {
+	return "Unsupported device state version " + formatInt(int(e.Version))
+}
+
+func (e *ErrUnsupportedStateVersion) Is(target error) bool {
+	_, ok := target.(*ErrUnsupportedStateVersion)
+	return ok
+}
+
+// ErrPowerLost is returned by a MemoryStorage after a simulated power loss.
+type ErrPowerLost struct {
+}
+
+func (e *ErrPowerLost) Error() string {
+	return "Power lost"
+}
+
+func (e *ErrPowerLost) Is(target error) bool {
+	_, ok := target.(*ErrPowerLost)
+	return ok
+}
+
+// ErrCounterSyncOutOfReach is returned when the device count is too far behind the server count to be synchronised.
+type ErrCounterSyncOutOfReach struct {
+	DeviceCount int
+	ServerCount int
+}
+
+func (e *ErrCounterSyncOutOfReach) Error() string {
+	return "Device count " + formatInt(e.DeviceCount) + " is too far behind the server count " + formatInt(e.ServerCount) + " to be synchronised"
+}
+
+func (e *ErrCounterSyncOutOfReach) Is(target error) bool {
+	_, ok := target.(*ErrCounterSyncOutOfReach)
+	return ok
+}
@@ -0,0 +1,83 @@
+package openpaygotoken
+
+import "time"
+
+// DeviceEventKind is the kind of event raised by a device.
+type DeviceEventKind int
+
+const (
+	// EventTokenAccepted is raised when a token is applied to the device.
+	EventTokenAccepted DeviceEventKind = 1
+	// EventTokenRejected is raised when a token is invalid or cannot be entered.
+	EventTokenRejected DeviceEventKind = 2
+	// EventTokenAlreadyUsed is raised when a valid token was already used.
+	EventTokenAlreadyUsed DeviceEventKind = 3
+	// EventLockout is raised when an invalid token blocks the token entry.
+	EventLockout DeviceEventKind = 4
+	// EventPaygDisabled is raised after EventTokenAccepted when the token disables PAYG.
+	EventPaygDisabled DeviceEventKind = 5
+)
+
+// String returns the name of the event kind.
+func (k DeviceEventKind) String() string {
+	switch k {
+	case EventTokenAccepted:
+		return "TokenAccepted"
+	case EventTokenRejected:
+		return "TokenRejected"
+	case EventTokenAlreadyUsed:
+		return "TokenAlreadyUsed"
+	case EventLockout:
+		return "Lockout"
+	case EventPaygDisabled:
+		return "PaygDisabled"
+	default:
+		return "Unknown"
+	}
+}
+
+// DeviceEvent is an outcome of a token entered in a device.
+type DeviceEvent struct {
+	Kind                DeviceEventKind
+	Token               string        // The digits of the token as entered
+	Count               int           // The count of an accepted token
+	Value               int           // The value of an accepted token
+	ExpirationTimestamp time.Time     // The expiration of the device after an accepted token
+	Wait                time.Duration // How long the token entry is blocked after a lockout
+	Err                 error         // The reason of a rejected or already used token
+}
+
+// deviceListener is a function subscribed to the events of a device.
+type deviceListener struct {
+	id       int
+	listener func(DeviceEvent)
+}
+
+// Subscribe calls the listener with each event of the device, once the device state is saved.
+// The listener is called synchronously from EnterToken and ApplyToken. The returned function unsubscribes it.
+func (d *Device) Subscribe(listener func(DeviceEvent)) func() {
+	d.lastListenerID++
+	id := d.lastListenerID
+	d.listeners = append(d.listeners, deviceListener{id: id, listener: listener})
+	return func() {
+		for index, subscribed := range d.listeners {
+			if subscribed.id == id {
+				d.listeners = append(d.listeners[:index:index], d.listeners[index+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// emit calls the listeners of the device with an event.
+func (d *Device) emit(event DeviceEvent) {
+	for _, subscribed := range d.listeners {
+		subscribed.listener(event)
+	}
+}
+
+// reject raises the rejection of a token and returns its error.
+func (d *Device) reject(digits string, err error) (*TokenResult, error) {
+	d.emit(DeviceEvent{Kind: EventTokenRejected, Token: digits, Err: err})
+	return nil, err
+}
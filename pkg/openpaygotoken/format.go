@@ -0,0 +1,37 @@
+package openpaygotoken
+
+// The package formats numbers without fmt or strconv, so the decode path builds for firmwares with small binaries.
+
+// formatInt returns the decimal digits of a number.
+func formatInt(n int) string {
+	if n < 0 {
+		return "-" + formatUint(uint64(-n), 0)
+	}
+	return formatUint(uint64(n), 0)
+}
+
+// formatUint returns the decimal digits of a number, padded with zeros on the left to the given width.
+func formatUint(n uint64, width int) string {
+	var digits [20]byte
+	position := len(digits)
+	for {
+		position--
+		digits[position] = byte('0' + n%10)
+		n /= 10
+		if n == 0 && len(digits)-position >= width {
+			return string(digits[position:])
+		}
+	}
+}
+
+// quoteByte returns a character between single quotes, escaped in hexadecimal if it is not printable.
+func quoteByte(c byte) string {
+	const hex = "0123456789abcdef"
+	switch {
+	case c == '\'' || c == '\\':
+		return `'\` + string(c) + `'`
+	case c < ' ' || c > '~':
+		return `'\x` + string([]byte{hex[c>>4], hex[c&0xf]}) + `'`
+	}
+	return "'" + string(c) + "'"
+}
@@ -2,7 +2,4 @@ module github.com/wan5xp/openpaygotoken/pkg/openpaygotoken
 
 go 1.20
 
-require (
-	github.com/aead/siphash v1.0.1
-	golang.org/x/exp v0.0.0-20230905200255-921286631fa9
-)
+require github.com/aead/siphash v1.0.1
@@ -0,0 +1,156 @@
+package openpaygotoken
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"time"
+)
+
+const (
+	journalVersion byte = 1
+	journalSize    int  = 27
+	journalName         = "journal"
+	extendedFlag   byte = 1
+)
+
+// journalRecord is the write-ahead record of a token being applied to the device state.
+type journalRecord struct {
+	baseSequence uint32 // The sequence number of the state the token is applied to
+	extended     bool
+	result       DecodeResult
+	now          time.Time
+}
+
+// encodeJournal returns the binary encoding of a journal record.
+func encodeJournal(record *journalRecord) []byte {
+	var flags byte
+	if record.extended {
+		flags |= extendedFlag
+	}
+	data := make([]byte, journalSize)
+	data[0] = journalVersion
+	binary.BigEndian.PutUint32(data[1:], record.baseSequence)
+	data[5] = flags
+	binary.BigEndian.PutUint32(data[6:], uint32(record.result.Value))
+	binary.BigEndian.PutUint32(data[10:], uint32(record.result.Count))
+	data[14] = byte(record.result.Type)
+	binary.BigEndian.PutUint64(data[15:], uint64(record.now.UnixNano()))
+	binary.BigEndian.PutUint32(data[23:], crc32.ChecksumIEEE(data[:23]))
+	return data
+}
+
+// decodeJournal returns the journal record of its binary encoding.
+func decodeJournal(data []byte) (*journalRecord, error) {
+	if len(data) != journalSize || crc32.ChecksumIEEE(data[:23]) != binary.BigEndian.Uint32(data[23:]) {
+		return nil, &ErrCorruptState{}
+	}
+	if data[0] != journalVersion {
+		return nil, &ErrUnsupportedStateVersion{Version: data[0]}
+	}
+	value := int(binary.BigEndian.Uint32(data[6:]))
+	tokenType := TokenType(data[14])
+	record := &journalRecord{
+		baseSequence: binary.BigEndian.Uint32(data[1:]),
+		extended:     data[5]&extendedFlag != 0,
+		result: DecodeResult{
+			Value: value,
+			Count: int(binary.BigEndian.Uint32(data[10:])),
+			Type:  tokenType,
+			Kind:  getTokenKind(value, tokenType),
+		},
+		now: time.Unix(0, int64(binary.BigEndian.Uint64(data[15:]))),
+	}
+	if record.extended {
+		record.result.Kind = KindAddTime
+	}
+	return record, nil
+}
+
+// Recover loads the device state from its storage and completes a token application interrupted by a power loss.
+// It must be called when the device boots, before any token is entered. If the storage is empty, the current state
+// is saved as the first state. If no saved state is valid, the device falls back to a locked state keeping its current
+// count, and ErrCorruptState is returned. Without a storage, there is nothing to recover and the state is kept.
+func (d *Device) Recover() error {
+	if d.Storage == nil {
+		return nil
+	}
+	copies := make([][]byte, 0, 2)
+	empty := true
+	for slot := 0; slot < 2; slot++ {
+		data, err := d.Storage.Read(stateRecordName(uint32(slot)))
+		if err != nil {
+			return err
+		}
+		empty = empty && len(data) == 0
+		copies = append(copies, data)
+	}
+	if empty {
+		return d.save()
+	}
+	state, sequence, loadErr := newestDeviceState(copies...)
+	if loadErr != nil {
+		state = LockedDeviceState(d.Count)
+	}
+	d.DeviceState, d.sequence = *state, sequence
+	data, err := d.Storage.Read(journalName)
+	if err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		// A valid journal on top of the saved state is a token that was accepted but not saved, a torn journal is a
+		// token that was never accepted.
+		if record, err := decodeJournal(data); err == nil && record.baseSequence == d.sequence {
+			d.commit(record.extended, &record.result, record.now)
+			if err = d.save(); err != nil {
+				return err
+			}
+		}
+		if err = d.Storage.Write(journalName, nil); err != nil {
+			return err
+		}
+	}
+	if loadErr != nil {
+		if err = d.save(); err != nil {
+			return err
+		}
+	}
+	return loadErr
+}
+
+// writeJournal writes the record of a token about to be applied to the device state.
+func (d *Device) writeJournal(extended bool, result *DecodeResult, now time.Time) error {
+	if d.Storage == nil {
+		return nil
+	}
+	record := &journalRecord{baseSequence: d.sequence, extended: extended, result: *result, now: now}
+	return d.Storage.Write(journalName, encodeJournal(record))
+}
+
+// clearJournal empties the journal once the device state is saved.
+func (d *Device) clearJournal() error {
+	if d.Storage == nil {
+		return nil
+	}
+	return d.Storage.Write(journalName, nil)
+}
+
+// save writes the device state in the slot of the next sequence number, keeping the previous state in the other slot.
+func (d *Device) save() error {
+	if d.Storage == nil {
+		return nil
+	}
+	data, err := EncodeDeviceState(&d.DeviceState, d.sequence+1)
+	if err != nil {
+		return err
+	}
+	if err = d.Storage.Write(stateRecordName(d.sequence+1), data); err != nil {
+		return err
+	}
+	d.sequence++
+	return nil
+}
+
+// stateRecordName returns the name of the storage record of a sequence number.
+func stateRecordName(sequence uint32) string {
+	return "state." + formatInt(int(sequence%2))
+}
@@ -0,0 +1,79 @@
+package openpaygotoken
+
+import "time"
+
+// LockoutPolicy decides how long the token entry is blocked after invalid tokens, to slow down brute force attempts.
+type LockoutPolicy interface {
+	// RegisterInvalidToken counts an invalid token entered at the given time and updates the blocking of the state.
+	RegisterInvalidToken(state *DeviceState, now time.Time)
+	// RemainingWait returns how long the token entry of the state is still blocked at the given time.
+	RemainingWait(state *DeviceState, now time.Time) time.Duration
+}
+
+// EscalatingLockout is the lockout of the OpenPAYGO reference implementation.
+// The token entry is blocked for 2 minutes after the first invalid token, and the blocking grows by
+// 2*InvalidTokenCount minutes for each invalid token after it.
+type EscalatingLockout struct {
+}
+
+// RegisterInvalidToken counts the invalid token and blocks the token entry for a growing period.
+func (EscalatingLockout) RegisterInvalidToken(state *DeviceState, now time.Time) {
+	state.InvalidTokenCount++
+	state.TokenEntryBlockedUntil = now.Add(2 * time.Minute)
+	for xn := 0; xn < state.InvalidTokenCount-1; xn++ {
+		state.TokenEntryBlockedUntil = state.TokenEntryBlockedUntil.Add(time.Duration(2*state.InvalidTokenCount) * time.Minute)
+	}
+}
+
+// RemainingWait returns the time left until the token entry is unblocked.
+func (EscalatingLockout) RemainingWait(state *DeviceState, now time.Time) time.Duration {
+	return remainingWait(state, now)
+}
+
+// FixedDelayLockout blocks the token entry for the same delay after each invalid token.
+type FixedDelayLockout struct {
+	Delay time.Duration
+}
+
+// RegisterInvalidToken counts the invalid token and blocks the token entry for the delay.
+func (l FixedDelayLockout) RegisterInvalidToken(state *DeviceState, now time.Time) {
+	state.InvalidTokenCount++
+	state.TokenEntryBlockedUntil = now.Add(l.Delay)
+}
+
+// RemainingWait returns the time left until the token entry is unblocked.
+func (FixedDelayLockout) RemainingWait(state *DeviceState, now time.Time) time.Duration {
+	return remainingWait(state, now)
+}
+
+// DailyAttemptsLockout allows a number of invalid tokens per day.
+// The day starts with the first invalid token, and once MaxAttempts invalid tokens are entered the token entry is
+// blocked until the end of the day.
+type DailyAttemptsLockout struct {
+	MaxAttempts int
+}
+
+// RegisterInvalidToken counts the invalid token in the current day and blocks the token entry if none is left.
+func (l DailyAttemptsLockout) RegisterInvalidToken(state *DeviceState, now time.Time) {
+	if state.InvalidTokenCount == 0 || !now.Before(state.FirstInvalidTokenAt.Add(24*time.Hour)) {
+		state.InvalidTokenCount = 0
+		state.FirstInvalidTokenAt = now
+	}
+	state.InvalidTokenCount++
+	if state.InvalidTokenCount >= l.MaxAttempts {
+		state.TokenEntryBlockedUntil = state.FirstInvalidTokenAt.Add(24 * time.Hour)
+	}
+}
+
+// RemainingWait returns the time left until the token entry is unblocked.
+func (DailyAttemptsLockout) RemainingWait(state *DeviceState, now time.Time) time.Duration {
+	return remainingWait(state, now)
+}
+
+// remainingWait returns the time left until the blocking of the state ends, 0 if it is not blocked.
+func remainingWait(state *DeviceState, now time.Time) time.Duration {
+	if !state.TokenEntryBlockedUntil.After(now) {
+		return 0
+	}
+	return state.TokenEntryBlockedUntil.Sub(now)
+}
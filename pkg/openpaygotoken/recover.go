@@ -0,0 +1,111 @@
+package openpaygotoken
+
+import (
+	"errors"
+	"sort"
+)
+
+// TokenEdit is the typing mistake corrected in a recovered token.
+type TokenEdit int
+
+const (
+	// EditNone is the edit of a token accepted as entered.
+	EditNone TokenEdit = 1
+	// EditSubstitution is the edit of a token with one digit replaced.
+	EditSubstitution TokenEdit = 2
+	// EditTransposition is the edit of a token with two adjacent digits swapped.
+	EditTransposition TokenEdit = 3
+)
+
+// String returns the name of the token edit.
+func (e TokenEdit) String() string {
+	switch e {
+	case EditNone:
+		return "None"
+	case EditSubstitution:
+		return "Substitution"
+	case EditTransposition:
+		return "Transposition"
+	default:
+		return "Unknown"
+	}
+}
+
+// TokenCandidate is a token the user may have meant to enter, accepted by the decoder.
+type TokenCandidate struct {
+	Token    string // The digits of the candidate
+	Edit     TokenEdit
+	Position int // The position of the replaced digit, or of the first swapped digit
+	Result   DecodeResult
+	keyGap   int // The distance on a phone keypad between the entered and the replaced digit, 0 for a transposition
+}
+
+// keypadPositions are the row and column of the digits on a phone keypad.
+var keypadPositions = [10][2]int{{3, 1}, {0, 0}, {0, 1}, {0, 2}, {1, 0}, {1, 1}, {1, 2}, {2, 0}, {2, 1}, {2, 2}}
+
+// RecoverToken returns the tokens the user may have meant to enter instead of a token with a typing mistake.
+// The candidates differ from the input by one replaced digit or two swapped adjacent digits, and are accepted by
+// the decoder. They are ranked from the most likely: the count closest to the next expected count first, then the
+// swapped digits, then the digits replaced by a nearby key of a phone keypad. If the input is accepted as entered,
+// it is the only candidate. If the input matches a count the decoder rejects, it was not mistyped and the error of the
+// decoder is returned, such as ErrValidOlderToken. No candidate is returned if the input cannot be corrected.
+func (d *TokenDecoder) RecoverToken(input string, startingCode int, key *[16]byte, lastCount int, used CountWindow) ([]TokenCandidate, error) {
+	// We do not check the restricted digits, so a wrong digit of a restricted token can be replaced
+	format, err := parseTokenFormat(input)
+	if err != nil {
+		return nil, err
+	}
+	digits := []byte(tokenDigits(input))
+	alphabet := "0123456789"
+	if format == RestrictedStandardFormat || format == RestrictedExtendedFormat {
+		alphabet = "1234"
+	}
+	var result DecodeResult
+	if err = d.DecodeDigits(&result, string(digits), startingCode, key, lastCount, used); err == nil {
+		return []TokenCandidate{{Token: string(digits), Edit: EditNone, Result: result}}, nil
+	} else if !errors.Is(err, &ErrInvalidToken{}) {
+		return nil, err
+	}
+
+	candidates := make([]TokenCandidate, 0)
+	try := func(edit TokenEdit, position int, keyGap int) {
+		if d.DecodeDigits(&result, string(digits), startingCode, key, lastCount, used) == nil {
+			candidates = append(candidates, TokenCandidate{Token: string(digits), Edit: edit, Position: position, Result: result, keyGap: keyGap})
+		}
+	}
+	for position, entered := range digits {
+		for i := 0; i < len(alphabet); i++ {
+			if alphabet[i] != entered {
+				digits[position] = alphabet[i]
+				try(EditSubstitution, position, keypadGap(entered, alphabet[i]))
+			}
+		}
+		digits[position] = entered
+	}
+	for position := 0; position+1 < len(digits); position++ {
+		if digits[position] != digits[position+1] {
+			digits[position], digits[position+1] = digits[position+1], digits[position]
+			try(EditTransposition, position, 0)
+			digits[position], digits[position+1] = digits[position+1], digits[position]
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		gapI, gapJ := distance(candidates[i].Result.Count, lastCount+1), distance(candidates[j].Result.Count, lastCount+1)
+		if gapI != gapJ {
+			return gapI < gapJ
+		}
+		return candidates[i].keyGap < candidates[j].keyGap
+	})
+	return candidates, nil
+}
+
+// keypadGap returns the distance between two keys of a phone keypad, 1 for neighbours including diagonals.
+func keypadGap(digit byte, otherDigit byte) int {
+	from, to := keypadPositions[digit-'0'], keypadPositions[otherDigit-'0']
+	rows, columns := distance(from[0], to[0]), distance(from[1], to[1])
+	if rows > columns {
+		return rows
+	}
+	return columns
+}
@@ -9,6 +9,9 @@ import (
 // TokenType is the type of token.
 type TokenType int
 
+// TokenKind is the effect of a token on the device.
+type TokenKind int
+
 const (
 	maxBase                  int = 999
 	tokenValueOffset         int = 1000
@@ -26,8 +29,49 @@ const (
 	AddTime TokenType = 2
 	// MaxActivationValue is the maximum value of an activation token.
 	MaxActivationValue int = 995
+	// MaxExtendedActivationValue is the maximum value of an extended token.
+	MaxExtendedActivationValue int = 999999
+
+	// KindAddTime is the kind of token adding time to the device.
+	KindAddTime TokenKind = 1
+	// KindSetTime is the kind of token setting the time of the device.
+	KindSetTime TokenKind = 2
+	// KindDisable is the kind of token disabling PAYG on the device.
+	KindDisable TokenKind = 3
+	// KindCounterSync is the kind of token synchronising the count of the device.
+	KindCounterSync TokenKind = 4
 )
 
+// String returns the name of the token kind.
+func (k TokenKind) String() string {
+	switch k {
+	case KindAddTime:
+		return "AddTime"
+	case KindSetTime:
+		return "SetTime"
+	case KindDisable:
+		return "Disable"
+	case KindCounterSync:
+		return "CounterSync"
+	default:
+		return "Unknown"
+	}
+}
+
+// getTokenKind returns the kind of a token from its value and type.
+func getTokenKind(value int, tokenType TokenType) TokenKind {
+	switch {
+	case value == CounterSyncValue:
+		return KindCounterSync
+	case value == PAYGDisableValue:
+		return KindDisable
+	case tokenType == SetTime:
+		return KindSetTime
+	default:
+		return KindAddTime
+	}
+}
+
 // GetTokenBase returns the base of the token.
 func getTokenBase(code int) int {
 	return code % tokenValueOffset
@@ -42,43 +86,41 @@ func putBaseInToken(token int, tokenBase int) (int, error) {
 }
 
 // GetTokenBaseExtended returns the base of the extended token.
-func getTokenBaseExtended(code int) int {
-	return code % tokenValueOffsetExtended
+func getTokenBaseExtended(code uint64) int {
+	return int(code % uint64(tokenValueOffsetExtended))
 }
 
 // PutBaseInTokenExtended returns the extended token with the given base.
-func putBaseInTokenExtended(token int, tokenBase int) (int, error) {
+func putBaseInTokenExtended(token uint64, tokenBase int) (uint64, error) {
 	if tokenBase > maxBaseExtended {
 		return 0, &ErrInvalidTokenBase{Value: tokenBase}
 	}
-	return token - getTokenBaseExtended(token) + tokenBase, nil
+	return token - uint64(getTokenBaseExtended(token)) + uint64(tokenBase), nil
 }
 
 // GenerateNextToken generates a token with the given parameters.
 func generateNextToken(lastCode int, key *[16]byte) int {
-	conformedToken := make([]byte, 8)
-	binary.BigEndian.PutUint32(conformedToken, uint32(lastCode))     // We convert the token to bytes
+	var conformedToken [8]byte
+	binary.BigEndian.PutUint32(conformedToken[:], uint32(lastCode))  // We convert the token to bytes
 	binary.BigEndian.PutUint32(conformedToken[4:], uint32(lastCode)) // We duplicate it to fit the minimum length
-	tokenHash := siphash.Sum64(conformedToken, key)                  // We hash it
+	tokenHash := siphash.Sum64(conformedToken[:], key)               // We hash it
 	newToken := convertHashToToken(tokenHash)                        // We convert to token and return
 	return newToken
 }
 
 // GenerateNextTokenExtended generates an extended token with the given parameters.
-func generateNextTokenExtended(lastCode int, key *[16]byte) int {
-	conformedToken := make([]byte, 8)
-	binary.BigEndian.PutUint64(conformedToken, uint64(lastCode)) // We convert the token to bytes
-	tokenHash := siphash.Sum64(conformedToken, key)              // We hash it
-	newToken := convertHashToTokenExtended(tokenHash)            // We convert to token and return
+func generateNextTokenExtended(lastCode uint64, key *[16]byte) uint64 {
+	var conformedToken [8]byte
+	binary.BigEndian.PutUint64(conformedToken[:], lastCode) // We convert the token to bytes
+	tokenHash := siphash.Sum64(conformedToken[:], key)      // We hash it
+	newToken := convertHashToTokenExtended(tokenHash)       // We convert to token and return
 	return newToken
 }
 
 // convertHashToToken converts hashed value to token.
 func convertHashToToken(thisHash uint64) int {
-	hashInt := make([]byte, 8)
-	binary.BigEndian.PutUint64(hashInt, thisHash)  // We convert the hash to bytes
-	hiHash := binary.BigEndian.Uint32(hashInt[:4]) // We split it in two 32bits INT
-	loHash := binary.BigEndian.Uint32(hashInt[4:])
+	hiHash := uint32(thisHash >> 32) // We split the hash in two 32bits INT
+	loHash := uint32(thisHash)
 	resultHash := hiHash ^ loHash           // We XOR the two together to get a single 32bits INT
 	token := convertoTo29_5Bits(resultHash) // We convert the 32bits value to an INT no greater than 9 digits
 	return int(token)
@@ -95,9 +137,8 @@ func convertoTo29_5Bits(source uint32) uint32 {
 }
 
 // convertHashToTokenExtended converts hashed value to token.
-func convertHashToTokenExtended(thisHash uint64) int {
-	token := convertoTo40BitsExtended(thisHash) // We convert the 64bits value to an INT no greater than 12 digits
-	return int(token)
+func convertHashToTokenExtended(thisHash uint64) uint64 {
+	return convertoTo40BitsExtended(thisHash) // We convert the 64bits value to an INT no greater than 12 digits
 }
 
 // convertTo40BitsExtended converts a 64bits value to an INT no greater than 12 digits.
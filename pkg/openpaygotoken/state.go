@@ -0,0 +1,131 @@
+package openpaygotoken
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"time"
+)
+
+const (
+	// DeviceStateVersion is the version of the binary encoding of the device state.
+	DeviceStateVersion byte = 2
+	// DeviceStateSize is the size of the binary encoding of the device state.
+	DeviceStateSize int = 68
+
+	deviceStateSizeV1 int  = 60 // The version 1 has no time of the first invalid token
+	paygEnabledFlag   byte = 1
+)
+
+// EncodeDeviceState returns the binary encoding of the device state, sized for an EEPROM or a flash page.
+// The sequence number tells which of two saved states is the newest.
+func EncodeDeviceState(state *DeviceState, sequence uint32) ([]byte, error) {
+	var flags byte
+	if state.PaygEnabled {
+		flags |= paygEnabledFlag
+	}
+	data := make([]byte, DeviceStateSize)
+	data[0] = DeviceStateVersion
+	binary.BigEndian.PutUint32(data[1:], sequence)
+	data[5] = flags
+	binary.BigEndian.PutUint32(data[6:], uint32(state.Count))
+	binary.BigEndian.PutUint32(data[10:], uint32(state.UsedCounts.Highest))
+	binary.BigEndian.PutUint64(data[14:], state.UsedCounts.Mask)
+	binary.BigEndian.PutUint32(data[22:], uint32(state.ExtendedCount))
+	binary.BigEndian.PutUint32(data[26:], uint32(state.ExtendedUsedCounts.Highest))
+	binary.BigEndian.PutUint64(data[30:], state.ExtendedUsedCounts.Mask)
+	binary.BigEndian.PutUint64(data[38:], uint64(state.ExpirationTimestamp.Unix()))
+	binary.BigEndian.PutUint16(data[46:], uint16(state.InvalidTokenCount))
+	binary.BigEndian.PutUint64(data[48:], uint64(state.TokenEntryBlockedUntil.Unix()))
+	binary.BigEndian.PutUint64(data[56:], uint64(state.FirstInvalidTokenAt.Unix()))
+	binary.BigEndian.PutUint32(data[64:], crc32.ChecksumIEEE(data[:64]))
+	return data, nil
+}
+
+// DecodeDeviceState returns the device state and the sequence number of its binary encoding.
+// If the data is truncated or its checksum does not match, ErrCorruptState is returned.
+// The states saved with the version 1 are still decoded.
+func DecodeDeviceState(data []byte) (*DeviceState, uint32, error) {
+	size := DeviceStateSize
+	if len(data) > 0 && data[0] == 1 {
+		size = deviceStateSizeV1
+	}
+	if len(data) != size {
+		return nil, 0, &ErrCorruptState{}
+	}
+	if crc32.ChecksumIEEE(data[:size-4]) != binary.BigEndian.Uint32(data[size-4:]) {
+		return nil, 0, &ErrCorruptState{}
+	}
+	if data[0] != 1 && data[0] != DeviceStateVersion {
+		return nil, 0, &ErrUnsupportedStateVersion{Version: data[0]}
+	}
+	state := &DeviceState{
+		Count:                  int(int32(binary.BigEndian.Uint32(data[6:]))),
+		UsedCounts:             CountWindow{Highest: int(int32(binary.BigEndian.Uint32(data[10:]))), Mask: binary.BigEndian.Uint64(data[14:])},
+		ExtendedCount:          int(int32(binary.BigEndian.Uint32(data[22:]))),
+		ExtendedUsedCounts:     CountWindow{Highest: int(int32(binary.BigEndian.Uint32(data[26:]))), Mask: binary.BigEndian.Uint64(data[30:])},
+		PaygEnabled:            data[5]&paygEnabledFlag != 0,
+		ExpirationTimestamp:    time.Unix(int64(binary.BigEndian.Uint64(data[38:])), 0),
+		InvalidTokenCount:      int(binary.BigEndian.Uint16(data[46:])),
+		TokenEntryBlockedUntil: time.Unix(int64(binary.BigEndian.Uint64(data[48:])), 0),
+	}
+	if data[0] >= 2 {
+		state.FirstInvalidTokenAt = time.Unix(int64(binary.BigEndian.Uint64(data[56:])), 0)
+	}
+	return state, binary.BigEndian.Uint32(data[1:]), nil
+}
+
+// LoadDeviceState returns the newest valid device state among the given saved copies.
+// If none of them is valid, a copy of the fallback state is returned with the error of the last copy.
+func LoadDeviceState(fallback *DeviceState, copies ...[]byte) (*DeviceState, error) {
+	newest, _, err := newestDeviceState(copies...)
+	if err != nil {
+		state := *fallback
+		return &state, err
+	}
+	return newest, nil
+}
+
+// newestDeviceState returns the valid device state with the highest sequence number among the given saved copies.
+func newestDeviceState(copies ...[]byte) (*DeviceState, uint32, error) {
+	var newest *DeviceState
+	var newestSequence uint32
+	var err error = &ErrCorruptState{}
+	for _, data := range copies {
+		state, sequence, decodeErr := DecodeDeviceState(data)
+		if decodeErr != nil {
+			err = decodeErr
+			continue
+		}
+		if newest == nil || sequence > newestSequence {
+			newest, newestSequence = state, sequence
+		}
+	}
+	if newest == nil {
+		return nil, 0, err
+	}
+	return newest, newestSequence, nil
+}
+
+// LockedDeviceState returns a device state with PAYG enabled and no activation left.
+// It is the safe fallback state when no saved state can be loaded.
+func LockedDeviceState(count int) *DeviceState {
+	return &DeviceState{
+		Count:       count,
+		PaygEnabled: true,
+	}
+}
+
+// MarshalBinary returns the binary encoding of the device state with the sequence number 0.
+func (s *DeviceState) MarshalBinary() ([]byte, error) {
+	return EncodeDeviceState(s, 0)
+}
+
+// UnmarshalBinary decodes the binary encoding of a device state.
+func (s *DeviceState) UnmarshalBinary(data []byte) error {
+	state, _, err := DecodeDeviceState(data)
+	if err != nil {
+		return err
+	}
+	*s = *state
+	return nil
+}
@@ -0,0 +1,82 @@
+package openpaygotoken
+
+import "sync"
+
+// Storage is the non volatile memory of a device, holding named records.
+type Storage interface {
+	// Read returns the data of a record, or nil if the record is empty.
+	Read(name string) ([]byte, error)
+	// Write replaces the data of a record, nil empties it.
+	Write(name string, data []byte) error
+}
+
+// MemoryStorage is a Storage in memory that can simulate a power loss in the middle of a write.
+type MemoryStorage struct {
+	mu          sync.Mutex
+	records     map[string][]byte
+	writesLeft  int
+	powerLossOn bool
+	powerLost   bool
+}
+
+// NewMemoryStorage creates a new empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{records: make(map[string][]byte)}
+}
+
+// Read returns a copy of the data of a record.
+func (s *MemoryStorage) Read(name string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.powerLost {
+		return nil, &ErrPowerLost{}
+	}
+	data, ok := s.records[name]
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte{}, data...), nil
+}
+
+// Write replaces the data of a record.
+// If a power loss is scheduled for this write, only the first half of the data is written and ErrPowerLost is returned.
+func (s *MemoryStorage) Write(name string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.powerLost {
+		return &ErrPowerLost{}
+	}
+	if s.powerLossOn {
+		if s.writesLeft == 0 {
+			s.powerLost = true
+			if len(data) > 0 {
+				s.records[name] = append([]byte{}, data[:len(data)/2]...)
+			}
+			return &ErrPowerLost{}
+		}
+		s.writesLeft--
+	}
+	if data == nil {
+		delete(s.records, name)
+	} else {
+		s.records[name] = append([]byte{}, data...)
+	}
+	return nil
+}
+
+// LosePowerAfter schedules a power loss after the given number of successful writes.
+// The following write is torn and every access fails until PowerOn is called.
+func (s *MemoryStorage) LosePowerAfter(writes int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.powerLossOn = true
+	s.writesLeft = writes
+}
+
+// PowerOn restores the access to the storage after a power loss and cancels any scheduled power loss.
+func (s *MemoryStorage) PowerOn() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.powerLossOn = false
+	s.powerLost = false
+}
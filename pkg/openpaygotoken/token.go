@@ -0,0 +1,137 @@
+package openpaygotoken
+
+// TokenFormat is the digit format of a token.
+type TokenFormat int
+
+const (
+	// StandardFormat is the format of 9 digits standard tokens.
+	StandardFormat TokenFormat = 1
+	// RestrictedStandardFormat is the format of 15 digits standard tokens using only the digits 1 to 4.
+	RestrictedStandardFormat TokenFormat = 2
+	// ExtendedFormat is the format of 12 digits extended tokens.
+	ExtendedFormat TokenFormat = 3
+	// RestrictedExtendedFormat is the format of 20 digits extended tokens using only the digits 1 to 4.
+	RestrictedExtendedFormat TokenFormat = 4
+
+	standardTokenLength           int = 9
+	restrictedStandardTokenLength int = 15
+	extendedTokenLength           int = 12
+	restrictedExtendedTokenLength int = 20
+)
+
+// String returns the name of the token format.
+func (f TokenFormat) String() string {
+	switch f {
+	case StandardFormat:
+		return "Standard"
+	case RestrictedStandardFormat:
+		return "RestrictedStandard"
+	case ExtendedFormat:
+		return "Extended"
+	case RestrictedExtendedFormat:
+		return "RestrictedExtended"
+	default:
+		return "Unknown"
+	}
+}
+
+// Token is a token parsed from a user entered string.
+type Token struct {
+	Digits string      // The digits of the token without separators
+	Format TokenFormat // The format detected from the number of digits
+	Code   uint64      // The token code, converted back from the restricted digit set if needed
+}
+
+// IsExtended returns true if the token is an extended token.
+func (t *Token) IsExtended() bool {
+	return t.Format == ExtendedFormat || t.Format == RestrictedExtendedFormat
+}
+
+// IsRestricted returns true if the token uses the restricted digit set.
+func (t *Token) IsRestricted() bool {
+	return t.Format == RestrictedStandardFormat || t.Format == RestrictedExtendedFormat
+}
+
+// String returns the digits of the token.
+func (t *Token) String() string {
+	return t.Digits
+}
+
+// ParseToken parses a token entered by a user.
+// Spaces, dashes, '*' and '#' are ignored. The format is detected from the number of digits:
+// 9 for standard, 15 for restricted standard, 12 for extended and 20 for restricted extended tokens.
+func ParseToken(input string) (*Token, error) {
+	code, format, err := ParseTokenCode(input)
+	if err != nil {
+		return nil, err
+	}
+	return &Token{Digits: tokenDigits(input), Format: format, Code: code}, nil
+}
+
+// ParseTokenCode parses a token entered by a user as ParseToken does, without allocating.
+// It returns the code and the format of the token.
+func ParseTokenCode(input string) (uint64, TokenFormat, error) {
+	format, err := parseTokenFormat(input)
+	if err != nil {
+		return 0, 0, err
+	}
+	restricted := format == RestrictedStandardFormat || format == RestrictedExtendedFormat
+	var code uint64
+	digitPosition := 0
+	for position := 0; position < len(input); position++ {
+		digit := input[position]
+		if digit < '0' || digit > '9' {
+			continue
+		}
+		if !restricted {
+			code = code*10 + uint64(digit-'0')
+		} else if digit < '1' || digit > '4' {
+			return 0, 0, &ErrInvalidRestrictedDigit{Digit: digit, Position: digitPosition}
+		} else {
+			code = code*4 + uint64(digit-'1')
+		}
+		digitPosition++
+	}
+	return code, format, nil
+}
+
+// parseTokenFormat checks the characters of a token entered by a user and detects its format from the number of
+// digits. The digits of a restricted token are not checked.
+func parseTokenFormat(input string) (TokenFormat, error) {
+	length := 0
+	for position := 0; position < len(input); position++ {
+		char := input[position]
+		switch {
+		case char >= '0' && char <= '9':
+			length++
+		case char == ' ' || char == '-' || char == '*' || char == '#':
+		default:
+			return 0, &ErrInvalidTokenCharacter{Char: char, Position: position}
+		}
+	}
+	switch length {
+	case 0:
+		return 0, &ErrEmptyToken{}
+	case standardTokenLength:
+		return StandardFormat, nil
+	case restrictedStandardTokenLength:
+		return RestrictedStandardFormat, nil
+	case extendedTokenLength:
+		return ExtendedFormat, nil
+	case restrictedExtendedTokenLength:
+		return RestrictedExtendedFormat, nil
+	default:
+		return 0, &ErrInvalidTokenLength{Length: length}
+	}
+}
+
+// tokenDigits returns the digits of a token entered by a user, without the separators.
+func tokenDigits(input string) string {
+	digits := make([]byte, 0, len(input))
+	for position := 0; position < len(input); position++ {
+		if input[position] >= '0' && input[position] <= '9' {
+			digits = append(digits, input[position])
+		}
+	}
+	return string(digits)
+}
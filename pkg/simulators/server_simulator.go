@@ -15,20 +15,33 @@ func (e *ErrTooManyDays) Error() string {
 	return "Too many days"
 }
 
+// ErrCounterSyncOutOfReach is returned when the device count is too far behind the server count to be synchronised.
+type ErrCounterSyncOutOfReach = openpaygotoken.ErrCounterSyncOutOfReach
+
 // SingleDeviceServerSimulator is a simulator for a single device server.
 type SingleDeviceServerSimulator struct {
 	StartingCode           int
 	Key                    [16]byte
 	Count                  int
+	ExtendedCount          int
 	ExpirationDate         time.Time
 	FurthestExpirationDate time.Time
 	PaygEnabled            bool
 	TimeDivider            int
 	RestrictedDigitSet     bool
+	Chain                  *openpaygotoken.TokenChain
+	Clock                  openpaygotoken.Clock
+	DecoderConfig          openpaygotoken.DecoderConfig // The windows of the device decoder, used to decide on counter syncs
 }
 
 // NewSingleDeviceServerSimulator creates a new SingleDeviceServerSimulator.
 func NewSingleDeviceServerSimulator(startingCode int, key *[16]byte, startingCount int, restrictedDigitSet bool, timeDivider int) *SingleDeviceServerSimulator {
+	return NewSingleDeviceServerSimulatorWithClock(openpaygotoken.RealClock{}, startingCode, key, startingCount, restrictedDigitSet, timeDivider)
+}
+
+// NewSingleDeviceServerSimulatorWithClock creates a new SingleDeviceServerSimulator reading the time from the given clock.
+func NewSingleDeviceServerSimulatorWithClock(clock openpaygotoken.Clock, startingCode int, key *[16]byte, startingCount int, restrictedDigitSet bool, timeDivider int) *SingleDeviceServerSimulator {
+	now := clock.Now()
 	return &SingleDeviceServerSimulator{
 		StartingCode:           startingCode,
 		Key:                    *key,
@@ -36,14 +49,27 @@ func NewSingleDeviceServerSimulator(startingCode int, key *[16]byte, startingCou
 		PaygEnabled:            true,
 		TimeDivider:            timeDivider,
 		RestrictedDigitSet:     restrictedDigitSet,
-		ExpirationDate:         time.Now(),
-		FurthestExpirationDate: time.Now(),
+		Chain:                  openpaygotoken.NewTokenChain(startingCode, key),
+		ExpirationDate:         now,
+		FurthestExpirationDate: now,
+		Clock:                  clock,
+		DecoderConfig:          openpaygotoken.OpenPAYGODecoderConfig(),
 	}
 }
 
 // GeneratePaygDisableToken generates a PAYG disable token.
 func (s *SingleDeviceServerSimulator) GeneratePaygDisableToken() (string, error) {
-	count, token, err := openpaygotoken.GenerateStandardToken(s.StartingCode, &s.Key, openpaygotoken.PAYGDisableValue, s.Count, openpaygotoken.SetTime, s.RestrictedDigitSet)
+	count, token, err := s.Chain.GenerateStandardToken(openpaygotoken.PAYGDisableValue, s.Count, openpaygotoken.SetTime, s.RestrictedDigitSet)
+	if err != nil {
+		return "", err
+	}
+	s.Count = count
+	return token, nil
+}
+
+// GenerateCounterSyncToken generates a counter sync token, setting the device count to the server count.
+func (s *SingleDeviceServerSimulator) GenerateCounterSyncToken() (string, error) {
+	count, token, err := s.Chain.GenerateStandardToken(openpaygotoken.CounterSyncValue, s.Count, openpaygotoken.AddTime, s.RestrictedDigitSet)
 	if err != nil {
 		return "", err
 	}
@@ -51,6 +77,27 @@ func (s *SingleDeviceServerSimulator) GeneratePaygDisableToken() (string, error)
 	return token, nil
 }
 
+// CounterSyncNeeded returns true if the next token of the server is too far above the device count to be accepted.
+func (s *SingleDeviceServerSimulator) CounterSyncNeeded(deviceCount int) bool {
+	needed, _ := s.DecoderConfig.CounterSyncNeeded(s.Count, deviceCount)
+	return needed
+}
+
+// SyncCounter synchronises the server with the count reported by a device.
+// If the device is ahead, the server count moves forward to it and no token is needed. If the next token of the
+// server would be too far above the device count, a counter sync token is returned. Otherwise the token is empty.
+// If the device is too far behind for a counter sync token, ErrCounterSyncOutOfReach is returned.
+func (s *SingleDeviceServerSimulator) SyncCounter(deviceCount int) (string, error) {
+	if deviceCount > s.Count {
+		s.Count = deviceCount
+		return "", nil
+	}
+	if needed, err := s.DecoderConfig.CounterSyncNeeded(s.Count, deviceCount); !needed || err != nil {
+		return "", err
+	}
+	return s.GenerateCounterSyncToken()
+}
+
 // GenerateTokenFromDate generates a token from a date
 func (s *SingleDeviceServerSimulator) GenerateTokenFromDate(newExpirationDate time.Time, force bool) (string, error) {
 	var value int
@@ -60,14 +107,14 @@ func (s *SingleDeviceServerSimulator) GenerateTokenFromDate(newExpirationDate ti
 		s.FurthestExpirationDate = newExpirationDate
 	}
 	if newExpirationDate.After(furthestExpirationDate) {
-		value, err = s.getValueToActivate(newExpirationDate, s.ExpirationDate, force)
+		value, err = s.getValueToActivate(newExpirationDate, s.ExpirationDate, openpaygotoken.MaxActivationValue, force)
 		if err != nil {
 			return "", err
 		}
 		s.ExpirationDate = newExpirationDate
 		return s.GenerateTokenFromValue(value, openpaygotoken.AddTime)
 	} else {
-		value, err = s.getValueToActivate(newExpirationDate, time.Now(), force)
+		value, err = s.getValueToActivate(newExpirationDate, s.now(), openpaygotoken.MaxActivationValue, force)
 		if err != nil {
 			return "", err
 		}
@@ -78,7 +125,7 @@ func (s *SingleDeviceServerSimulator) GenerateTokenFromDate(newExpirationDate ti
 
 // GenerateTokenFromValue generates a token from a value
 func (s *SingleDeviceServerSimulator) GenerateTokenFromValue(value int, mode openpaygotoken.TokenType) (string, error) {
-	count, token, err := openpaygotoken.GenerateStandardToken(s.StartingCode, &s.Key, value, s.Count, mode, s.RestrictedDigitSet)
+	count, token, err := s.Chain.GenerateStandardToken(value, s.Count, mode, s.RestrictedDigitSet)
 	if err != nil {
 		return "", err
 	}
@@ -86,20 +133,91 @@ func (s *SingleDeviceServerSimulator) GenerateTokenFromValue(value int, mode ope
 	return token, nil
 }
 
+// GenerateTokensFromDate generates the tokens setting the expiration date, as GenerateTokenFromDate does, without
+// limiting the activation to MaxActivationValue. The tokens must be entered in the returned order.
+func (s *SingleDeviceServerSimulator) GenerateTokensFromDate(newExpirationDate time.Time) ([]string, error) {
+	furthestExpirationDate := s.FurthestExpirationDate
+	if newExpirationDate.After(s.FurthestExpirationDate) {
+		s.FurthestExpirationDate = newExpirationDate
+	}
+	if newExpirationDate.After(furthestExpirationDate) {
+		value, err := s.getValueToActivate(newExpirationDate, s.ExpirationDate, math.MaxInt32, false)
+		if err != nil {
+			return nil, err
+		}
+		s.ExpirationDate = newExpirationDate
+		return s.GenerateTokensFromValue(value, openpaygotoken.AddTime)
+	}
+	value, err := s.getValueToActivate(newExpirationDate, s.now(), math.MaxInt32, false)
+	if err != nil {
+		return nil, err
+	}
+	s.ExpirationDate = newExpirationDate
+	return s.GenerateTokensFromValue(value, openpaygotoken.SetTime)
+}
+
+// GenerateTokensFromValue generates the tokens activating a value of any size.
+// The value is split by SplitActivationValue. With SetTime, the first token sets the time and the next ones add
+// time. The tokens must be entered in the returned order.
+func (s *SingleDeviceServerSimulator) GenerateTokensFromValue(value int, mode openpaygotoken.TokenType) ([]string, error) {
+	values := openpaygotoken.SplitActivationValue(value, s.TimeDivider)
+	tokens := make([]string, 0, len(values))
+	for _, tokenValue := range values {
+		token, err := s.GenerateTokenFromValue(tokenValue, mode)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+		mode = openpaygotoken.AddTime
+	}
+	return tokens, nil
+}
+
+// GenerateExtendedTokenFromDate generates an extended token adding the time up to a date.
+// Extended tokens can only add time, the date must be after the current expiration date.
+func (s *SingleDeviceServerSimulator) GenerateExtendedTokenFromDate(newExpirationDate time.Time, force bool) (string, error) {
+	value, err := s.getValueToActivate(newExpirationDate, s.ExpirationDate, openpaygotoken.MaxExtendedActivationValue, force)
+	if err != nil {
+		return "", err
+	}
+	if newExpirationDate.After(s.ExpirationDate) {
+		s.ExpirationDate = newExpirationDate
+	}
+	if newExpirationDate.After(s.FurthestExpirationDate) {
+		s.FurthestExpirationDate = newExpirationDate
+	}
+	return s.GenerateExtendedTokenFromValue(value)
+}
+
+// GenerateExtendedTokenFromValue generates an extended token from a value
+func (s *SingleDeviceServerSimulator) GenerateExtendedTokenFromValue(value int) (string, error) {
+	count, token, err := s.Chain.GenerateExtendedToken(value, s.ExtendedCount, s.RestrictedDigitSet)
+	if err != nil {
+		return "", err
+	}
+	s.ExtendedCount = count
+	return token, nil
+}
+
 // GetValueToActivate returns the value to activate.
-func (s *SingleDeviceServerSimulator) getValueToActivate(newTime time.Time, referenceTime time.Time, forceMaximum bool) (int, error) {
+func (s *SingleDeviceServerSimulator) getValueToActivate(newTime time.Time, referenceTime time.Time, maxValue int, forceMaximum bool) (int, error) {
 	if !newTime.After(referenceTime) {
 		return 0, nil
 	} else {
 		days := math.Round(newTime.Sub(referenceTime).Hours() / 24)
 		value := int(days) * s.TimeDivider
-		if value > openpaygotoken.MaxActivationValue {
+		if value > maxValue {
 			if !forceMaximum {
 				return 0, &ErrTooManyDays{}
 			} else {
-				return openpaygotoken.MaxActivationValue, nil
+				return maxValue, nil
 			}
 		}
 		return value, nil
 	}
 }
+
+// now returns the time of the clock of the server.
+func (s *SingleDeviceServerSimulator) now() time.Time {
+	return openpaygotoken.NowFrom(s.Clock)
+}
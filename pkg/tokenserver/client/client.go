@@ -0,0 +1,169 @@
+// Package client is a typed client of the JSON API served by tokenserver.NewHandler, described in api/openapi.yaml.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/wan5xp/openpaygotoken/pkg/tokenserver"
+)
+
+// ErrAPI is returned when the server answers a request with an error status.
+type ErrAPI struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ErrAPI) Error() string {
+	return fmt.Sprintf("Token server error %d: %s", e.StatusCode, e.Message)
+}
+
+func (e *ErrAPI) Is(target error) bool {
+	_, ok := target.(*ErrAPI)
+	return ok
+}
+
+// Client sends the requests of the token server API.
+type Client struct {
+	BaseURL    string // The URL the API paths are appended to, such as http://localhost:8080
+	Token      string // The bearer token sent in the Authorization header, if not empty
+	HTTPClient *http.Client
+}
+
+// New creates a new Client of the server at the given URL, using http.DefaultClient.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), HTTPClient: http.DefaultClient}
+}
+
+// ListDevices returns all the devices, sorted by serial number.
+func (c *Client) ListDevices(ctx context.Context) ([]tokenserver.DeviceResponse, error) {
+	var response tokenserver.DevicesResponse
+	if err := c.do(ctx, http.MethodGet, "/devices", nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Devices, nil
+}
+
+// RegisterDevice registers a new device with PAYG enabled and no activation.
+func (c *Client) RegisterDevice(ctx context.Context, device tokenserver.DeviceRequest) (*tokenserver.DeviceResponse, error) {
+	var response tokenserver.DeviceResponse
+	if err := c.do(ctx, http.MethodPost, "/devices", device, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// GetDevice returns the state of a device.
+func (c *Client) GetDevice(ctx context.Context, serial string) (*tokenserver.DeviceResponse, error) {
+	var response tokenserver.DeviceResponse
+	if err := c.do(ctx, http.MethodGet, devicePath(serial), nil, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// GetHistory returns the tokens issued for a device.
+func (c *Client) GetHistory(ctx context.Context, serial string) ([]tokenserver.TokenResponse, error) {
+	return c.tokens(ctx, http.MethodGet, devicePath(serial)+"/tokens", nil)
+}
+
+// AddTime issues the tokens adding a value to a device, in the order to enter them.
+func (c *Client) AddTime(ctx context.Context, serial string, value int) ([]tokenserver.TokenResponse, error) {
+	return c.tokens(ctx, http.MethodPost, devicePath(serial)+"/tokens/add-time", tokenserver.TokenRequest{Value: &value})
+}
+
+// SetTime issues the tokens setting the value of a device, in the order to enter them.
+func (c *Client) SetTime(ctx context.Context, serial string, value int) ([]tokenserver.TokenResponse, error) {
+	return c.tokens(ctx, http.MethodPost, devicePath(serial)+"/tokens/set-time", tokenserver.TokenRequest{Value: &value})
+}
+
+// SetExpirationDate issues the tokens setting the expiration date of a device, in the order to enter them.
+func (c *Client) SetExpirationDate(ctx context.Context, serial string, expirationDate time.Time) ([]tokenserver.TokenResponse, error) {
+	return c.tokens(ctx, http.MethodPost, devicePath(serial)+"/tokens/expiration-date", tokenserver.TokenRequest{ExpirationDate: expirationDate})
+}
+
+// AddExtendedTime issues an extended token adding a value to a device.
+func (c *Client) AddExtendedTime(ctx context.Context, serial string, value int) (*tokenserver.TokenResponse, error) {
+	return c.token(ctx, devicePath(serial)+"/tokens/extended", tokenserver.TokenRequest{Value: &value})
+}
+
+// DisablePayg issues the token disabling PAYG on a device.
+func (c *Client) DisablePayg(ctx context.Context, serial string) (*tokenserver.TokenResponse, error) {
+	return c.token(ctx, devicePath(serial)+"/tokens/disable", nil)
+}
+
+// SyncCounter synchronises the server with the count reported by a device.
+// It returns the counter sync token to enter, or nil if the device needs none.
+func (c *Client) SyncCounter(ctx context.Context, serial string, deviceCount int) (*tokenserver.TokenResponse, error) {
+	return c.token(ctx, devicePath(serial)+"/tokens/counter-sync", tokenserver.TokenRequest{DeviceCount: &deviceCount})
+}
+
+// tokens sends a request returning a list of tokens.
+func (c *Client) tokens(ctx context.Context, method string, path string, body interface{}) ([]tokenserver.TokenResponse, error) {
+	var response tokenserver.TokensResponse
+	if err := c.do(ctx, method, path, body, &response); err != nil {
+		return nil, err
+	}
+	return response.Tokens, nil
+}
+
+// token sends a request issuing at most one token.
+func (c *Client) token(ctx context.Context, path string, body interface{}) (*tokenserver.TokenResponse, error) {
+	tokens, err := c.tokens(ctx, http.MethodPost, path, body)
+	if err != nil || len(tokens) == 0 {
+		return nil, err
+	}
+	return &tokens[0], nil
+}
+
+// do sends a request with a JSON body, if any, and decodes the JSON response.
+func (c *Client) do(ctx context.Context, method string, path string, body interface{}, response interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	if c.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	httpResponse, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+	if httpResponse.StatusCode >= 300 {
+		var apiError tokenserver.ErrorResponse
+		if json.NewDecoder(httpResponse.Body).Decode(&apiError) != nil || apiError.Error == "" {
+			apiError.Error = http.StatusText(httpResponse.StatusCode)
+		}
+		return &ErrAPI{StatusCode: httpResponse.StatusCode, Message: apiError.Error}
+	}
+	return json.NewDecoder(httpResponse.Body).Decode(response)
+}
+
+// devicePath returns the path of a device.
+func devicePath(serial string) string {
+	return "/devices/" + url.PathEscape(serial)
+}
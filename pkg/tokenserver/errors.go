@@ -0,0 +1,66 @@
+package tokenserver
+
+import (
+	"fmt"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+)
+
+// ErrDeviceNotFound is returned when no device has the serial number.
+type ErrDeviceNotFound struct {
+	Serial string
+}
+
+func (e *ErrDeviceNotFound) Error() string {
+	return fmt.Sprintf("Device %s not found", e.Serial)
+}
+
+func (e *ErrDeviceNotFound) Is(target error) bool {
+	_, ok := target.(*ErrDeviceNotFound)
+	return ok
+}
+
+// ErrDeviceExists is returned when a device is created with the serial number of another device.
+type ErrDeviceExists struct {
+	Serial string
+}
+
+func (e *ErrDeviceExists) Error() string {
+	return fmt.Sprintf("Device %s already exists", e.Serial)
+}
+
+func (e *ErrDeviceExists) Is(target error) bool {
+	_, ok := target.(*ErrDeviceExists)
+	return ok
+}
+
+// ErrInvalidDevice is returned when a device record is missing a field or has a field out of range.
+type ErrInvalidDevice struct {
+	Field string
+}
+
+func (e *ErrInvalidDevice) Error() string {
+	return fmt.Sprintf("Invalid device %s", e.Field)
+}
+
+func (e *ErrInvalidDevice) Is(target error) bool {
+	_, ok := target.(*ErrInvalidDevice)
+	return ok
+}
+
+// ErrInvalidValue is returned when the value of a token is out of range.
+type ErrInvalidValue struct {
+	Value int
+}
+
+func (e *ErrInvalidValue) Error() string {
+	return fmt.Sprintf("Invalid token value %d", e.Value)
+}
+
+func (e *ErrInvalidValue) Is(target error) bool {
+	_, ok := target.(*ErrInvalidValue)
+	return ok
+}
+
+// ErrCounterSyncOutOfReach is returned when the device count is too far behind the server count to be synchronised.
+type ErrCounterSyncOutOfReach = openpaygotoken.ErrCounterSyncOutOfReach
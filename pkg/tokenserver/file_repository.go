@@ -0,0 +1,148 @@
+package tokenserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// FileRepository is a DeviceRepository saved as a JSON file.
+// The file is rewritten on each change, through a temporary file so a crash never leaves it half written.
+// It must only be used by a single process.
+type FileRepository struct {
+	path  string
+	mu    sync.Mutex // Guards the file
+	locks sync.Map   // The *sync.Mutex serialising the updates of each device
+}
+
+// NewFileRepository creates a FileRepository saved at the given path. The file is created on the first change.
+func NewFileRepository(path string) *FileRepository {
+	return &FileRepository{path: path}
+}
+
+// Create adds a new device record to the file.
+func (r *FileRepository) Create(ctx context.Context, record *DeviceRecord) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	devices, err := r.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := devices[record.Serial]; ok {
+		return &ErrDeviceExists{Serial: record.Serial}
+	}
+	devices[record.Serial] = record.clone()
+	return r.save(devices)
+}
+
+// Get returns a device record read from the file.
+func (r *FileRepository) Get(ctx context.Context, serial string) (*DeviceRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	devices, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	record, ok := devices[serial]
+	if !ok {
+		return nil, &ErrDeviceNotFound{Serial: serial}
+	}
+	return record, nil
+}
+
+// Update calls update with a device record read from the file while holding the lock of the device.
+// Only the updated device is replaced in the file, the other devices can be updated at the same time.
+func (r *FileRepository) Update(ctx context.Context, serial string, update func(record *DeviceRecord) error) error {
+	lock, _ := r.locks.LoadOrStore(serial, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+	record, err := r.Get(ctx, serial)
+	if err != nil {
+		return err
+	}
+	if err = update(record); err != nil {
+		return err
+	}
+	record.Serial = serial
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	devices, err := r.load()
+	if err != nil {
+		return err
+	}
+	devices[serial] = record
+	return r.save(devices)
+}
+
+// List returns all the device records of the file.
+func (r *FileRepository) List(ctx context.Context) ([]*DeviceRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	devices, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*DeviceRecord, 0, len(devices))
+	for _, record := range devices {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Serial < records[j].Serial
+	})
+	return records, nil
+}
+
+// load reads the device records of the file, none if it does not exist yet.
+func (r *FileRepository) load() (map[string]*DeviceRecord, error) {
+	devices := make(map[string]*DeviceRecord)
+	data, err := os.ReadFile(r.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return devices, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// save writes the device records to a temporary file and renames it over the file.
+func (r *FileRepository) save(devices map[string]*DeviceRecord) error {
+	data, err := json.MarshalIndent(devices, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(r.path), filepath.Base(r.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), r.path)
+}
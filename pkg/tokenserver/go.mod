@@ -0,0 +1,12 @@
+module github.com/wan5xp/openpaygotoken/pkg/tokenserver
+
+go 1.20
+
+require (
+	github.com/mattn/go-sqlite3 v1.14.22
+	github.com/wan5xp/openpaygotoken/pkg/openpaygotoken v0.0.0-00010101000000-000000000000
+)
+
+require github.com/aead/siphash v1.0.1 // indirect
+
+replace github.com/wan5xp/openpaygotoken/pkg/openpaygotoken => ../openpaygotoken
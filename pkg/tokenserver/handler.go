@@ -0,0 +1,335 @@
+package tokenserver
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+)
+
+// maxRequestBytes is the largest request body read by the handler.
+const maxRequestBytes = 1 << 20
+
+// DeviceRequest is the body registering a device.
+type DeviceRequest struct {
+	Serial             string `json:"serial"`
+	StartingCode       int    `json:"starting_code"`
+	Key                string `json:"key"` // The 16 bytes of the secret key in hexadecimal
+	TimeDivider        int    `json:"time_divider,omitempty"`
+	RestrictedDigitSet bool   `json:"restricted_digit_set,omitempty"`
+	Count              int    `json:"count"`
+}
+
+// DeviceResponse is the state of a device returned by the API. The secret key is never returned.
+type DeviceResponse struct {
+	Serial                 string    `json:"serial"`
+	TimeDivider            int       `json:"time_divider"`
+	RestrictedDigitSet     bool      `json:"restricted_digit_set"`
+	Count                  int       `json:"count"`
+	ExtendedCount          int       `json:"extended_count"`
+	ExpirationDate         time.Time `json:"expiration_date"`
+	FurthestExpirationDate time.Time `json:"furthest_expiration_date"`
+	PaygEnabled            bool      `json:"payg_enabled"`
+}
+
+// TokenRequest is the body issuing tokens. Value is required by the add time, set time and extended tokens,
+// ExpirationDate by the expiration date tokens and DeviceCount by the counter sync.
+// The fields are pointers so a zero value is sent, and a missing field is told apart from it.
+type TokenRequest struct {
+	Value          *int      `json:"value,omitempty"`
+	ExpirationDate time.Time `json:"expiration_date"`
+	DeviceCount    *int      `json:"device_count,omitempty"`
+}
+
+// TokenResponse is a token returned by the API.
+type TokenResponse struct {
+	Token    string    `json:"token"`
+	Count    int       `json:"count"`
+	Value    int       `json:"value"`
+	Kind     string    `json:"kind"`
+	Extended bool      `json:"extended"`
+	IssuedAt time.Time `json:"issued_at"`
+}
+
+// TokensResponse is the list of tokens issued by a request or recorded for a device, in the order to enter them.
+type TokensResponse struct {
+	Tokens []TokenResponse `json:"tokens"`
+}
+
+// DevicesResponse is the list of devices.
+type DevicesResponse struct {
+	Devices []DeviceResponse `json:"devices"`
+}
+
+// ErrorResponse is the body returned with an error status.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// handler serves the JSON API of a TokenServer.
+type handler struct {
+	server *TokenServer
+}
+
+// NewHandler returns the HTTP handler of the JSON API of a TokenServer:
+//
+//	GET  /devices                                 lists the devices
+//	POST /devices                                 registers a device
+//	GET  /devices/{serial}                        returns the state of a device
+//	GET  /devices/{serial}/tokens                 returns the tokens issued for a device
+//	POST /devices/{serial}/tokens/add-time        issues the tokens adding a value
+//	POST /devices/{serial}/tokens/set-time        issues the tokens setting a value
+//	POST /devices/{serial}/tokens/expiration-date issues the tokens setting an expiration date
+//	POST /devices/{serial}/tokens/extended        issues an extended token adding a value
+//	POST /devices/{serial}/tokens/disable         issues the token disabling PAYG
+//	POST /devices/{serial}/tokens/counter-sync    issues a counter sync token if the device count needs one
+func NewHandler(server *TokenServer) http.Handler {
+	return &handler{server: server}
+}
+
+// RequireBearerToken returns a handler answering 401 to the requests without the bearer token in their
+// Authorization header, and passing the others to the next handler.
+func RequireBearerToken(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if path[0] != "devices" || len(path) > 4 || len(path) > 1 && path[1] == "" {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	switch {
+	case len(path) == 1:
+		h.serveDevices(w, r)
+	case len(path) == 2:
+		h.serveDevice(w, r, path[1])
+	case path[2] != "tokens":
+		writeError(w, http.StatusNotFound, "Not found")
+	case len(path) == 3:
+		h.serveHistory(w, r, path[1])
+	default:
+		h.serveTokens(w, r, path[1], path[3])
+	}
+}
+
+// serveDevices lists or registers devices.
+func (h *handler) serveDevices(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		records, err := h.server.ListDevices(r.Context())
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		devices := make([]DeviceResponse, 0, len(records))
+		for _, record := range records {
+			devices = append(devices, newDeviceResponse(record))
+		}
+		writeJSON(w, http.StatusOK, DevicesResponse{Devices: devices})
+	case http.MethodPost:
+		var request DeviceRequest
+		if !readJSON(w, r, &request) {
+			return
+		}
+		record := &DeviceRecord{
+			Serial:             request.Serial,
+			StartingCode:       request.StartingCode,
+			TimeDivider:        request.TimeDivider,
+			RestrictedDigitSet: request.RestrictedDigitSet,
+			Count:              request.Count,
+		}
+		if record.TimeDivider == 0 {
+			record.TimeDivider = 1
+		}
+		key, err := hex.DecodeString(request.Key)
+		if err != nil || len(key) != len(record.Key) {
+			writeServerError(w, &ErrInvalidDevice{Field: "key"})
+			return
+		}
+		copy(record.Key[:], key)
+		record, err = h.server.RegisterDevice(r.Context(), record)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, newDeviceResponse(record))
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// serveDevice returns the state of a device.
+func (h *handler) serveDevice(w http.ResponseWriter, r *http.Request, serial string) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	record, err := h.server.GetDevice(r.Context(), serial)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newDeviceResponse(record))
+}
+
+// serveHistory returns the tokens issued for a device.
+func (h *handler) serveHistory(w http.ResponseWriter, r *http.Request, serial string) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	record, err := h.server.GetDevice(r.Context(), serial)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newTokensResponse(record.History))
+}
+
+// serveTokens issues tokens for a device.
+func (h *handler) serveTokens(w http.ResponseWriter, r *http.Request, serial string, kind string) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	var request TokenRequest
+	if r.ContentLength != 0 && !readJSON(w, r, &request) {
+		return
+	}
+	var tokens []TokenRecord
+	var token *TokenRecord
+	var err error
+	switch kind {
+	case "add-time", "set-time", "extended":
+		if request.Value == nil {
+			writeError(w, http.StatusBadRequest, "Missing value")
+			return
+		}
+	case "counter-sync":
+		if request.DeviceCount == nil {
+			writeError(w, http.StatusBadRequest, "Missing device count")
+			return
+		}
+	}
+	switch kind {
+	case "add-time":
+		tokens, err = h.server.GenerateTokensFromValue(r.Context(), serial, *request.Value, openpaygotoken.AddTime)
+	case "set-time":
+		tokens, err = h.server.GenerateTokensFromValue(r.Context(), serial, *request.Value, openpaygotoken.SetTime)
+	case "expiration-date":
+		if request.ExpirationDate.IsZero() {
+			writeError(w, http.StatusBadRequest, "Missing expiration date")
+			return
+		}
+		tokens, err = h.server.GenerateTokensFromDate(r.Context(), serial, request.ExpirationDate)
+	case "extended":
+		token, err = h.server.GenerateExtendedTokenFromValue(r.Context(), serial, *request.Value)
+	case "disable":
+		token, err = h.server.GeneratePaygDisableToken(r.Context(), serial)
+	case "counter-sync":
+		token, err = h.server.SyncCounter(r.Context(), serial, *request.DeviceCount)
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	if token != nil {
+		tokens = []TokenRecord{*token}
+	}
+	writeJSON(w, http.StatusOK, newTokensResponse(tokens))
+}
+
+// newDeviceResponse returns the API state of a device record.
+func newDeviceResponse(record *DeviceRecord) DeviceResponse {
+	return DeviceResponse{
+		Serial:                 record.Serial,
+		TimeDivider:            record.TimeDivider,
+		RestrictedDigitSet:     record.RestrictedDigitSet,
+		Count:                  record.Count,
+		ExtendedCount:          record.ExtendedCount,
+		ExpirationDate:         record.ExpirationDate,
+		FurthestExpirationDate: record.FurthestExpirationDate,
+		PaygEnabled:            record.PaygEnabled,
+	}
+}
+
+// newTokensResponse returns the API list of token records.
+func newTokensResponse(records []TokenRecord) TokensResponse {
+	tokens := make([]TokenResponse, 0, len(records))
+	for _, record := range records {
+		tokens = append(tokens, TokenResponse{
+			Token:    record.Token,
+			Count:    record.Count,
+			Value:    record.Value,
+			Kind:     record.Kind.String(),
+			Extended: record.Extended,
+			IssuedAt: record.IssuedAt,
+		})
+	}
+	return TokensResponse{Tokens: tokens}
+}
+
+// readJSON decodes the body of a request of up to maxRequestBytes, or writes a bad request error.
+func readJSON(w http.ResponseWriter, r *http.Request, body interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+		} else {
+			writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		}
+		return false
+	}
+	return true
+}
+
+// writeJSON writes a JSON response.
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeError writes an error response.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, ErrorResponse{Error: message})
+}
+
+// writeMethodNotAllowed writes the error of a method the path does not accept.
+func writeMethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+}
+
+// writeServerError writes the response of an error returned by the TokenServer.
+func writeServerError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, &ErrDeviceNotFound{}):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, &ErrDeviceExists{}), errors.Is(err, &ErrCounterSyncOutOfReach{}):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, &ErrInvalidDevice{}), errors.Is(err, &ErrInvalidValue{}):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	}
+}
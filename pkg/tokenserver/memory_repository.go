@@ -0,0 +1,103 @@
+package tokenserver
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// MemoryRepository is a DeviceRepository in memory.
+type MemoryRepository struct {
+	mu      sync.Mutex
+	devices map[string]*memoryDevice
+}
+
+// memoryDevice is a device record with the lock serialising its updates.
+type memoryDevice struct {
+	mu     sync.Mutex
+	record *DeviceRecord
+}
+
+// NewMemoryRepository creates a new empty MemoryRepository.
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{devices: make(map[string]*memoryDevice)}
+}
+
+// Create stores a copy of a new device record.
+func (r *MemoryRepository) Create(ctx context.Context, record *DeviceRecord) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.devices[record.Serial]; ok {
+		return &ErrDeviceExists{Serial: record.Serial}
+	}
+	r.devices[record.Serial] = &memoryDevice{record: record.clone()}
+	return nil
+}
+
+// Get returns a copy of a device record.
+func (r *MemoryRepository) Get(ctx context.Context, serial string) (*DeviceRecord, error) {
+	device, err := r.device(ctx, serial)
+	if err != nil {
+		return nil, err
+	}
+	device.mu.Lock()
+	defer device.mu.Unlock()
+	return device.record.clone(), nil
+}
+
+// Update calls update with a copy of a device record while holding the lock of the device.
+func (r *MemoryRepository) Update(ctx context.Context, serial string, update func(record *DeviceRecord) error) error {
+	device, err := r.device(ctx, serial)
+	if err != nil {
+		return err
+	}
+	device.mu.Lock()
+	defer device.mu.Unlock()
+	record := device.record.clone()
+	if err = update(record); err != nil {
+		return err
+	}
+	record.Serial = serial
+	device.record = record
+	return nil
+}
+
+// List returns a copy of all the device records.
+func (r *MemoryRepository) List(ctx context.Context) ([]*DeviceRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	devices := make([]*memoryDevice, 0, len(r.devices))
+	for _, device := range r.devices {
+		devices = append(devices, device)
+	}
+	r.mu.Unlock()
+	records := make([]*DeviceRecord, 0, len(devices))
+	for _, device := range devices {
+		device.mu.Lock()
+		records = append(records, device.record.clone())
+		device.mu.Unlock()
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Serial < records[j].Serial
+	})
+	return records, nil
+}
+
+// device returns the device of a serial number.
+func (r *MemoryRepository) device(ctx context.Context, serial string) (*memoryDevice, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	device, ok := r.devices[serial]
+	if !ok {
+		return nil, &ErrDeviceNotFound{Serial: serial}
+	}
+	return device, nil
+}
@@ -0,0 +1,78 @@
+package tokenserver
+
+import (
+	"context"
+	"time"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+)
+
+// DeviceRecord is the server side state of a device.
+type DeviceRecord struct {
+	Serial                 string                     `json:"serial"`
+	StartingCode           int                        `json:"starting_code"`
+	Key                    [16]byte                   `json:"key"`
+	TimeDivider            int                        `json:"time_divider"`
+	RestrictedDigitSet     bool                       `json:"restricted_digit_set"`
+	Count                  int                        `json:"count"`
+	ExtendedCount          int                        `json:"extended_count"`
+	ExpirationDate         time.Time                  `json:"expiration_date"`
+	FurthestExpirationDate time.Time                  `json:"furthest_expiration_date"`
+	PaygEnabled            bool                       `json:"payg_enabled"`
+	Chain                  *openpaygotoken.TokenChain `json:"chain,omitempty"` // The checkpoints of the token generation, without the key
+	History                []TokenRecord              `json:"history"`
+}
+
+// TokenRecord is a token issued for a device.
+type TokenRecord struct {
+	Token    string                   `json:"token"`
+	Count    int                      `json:"count"`
+	Value    int                      `json:"value"`
+	Kind     openpaygotoken.TokenKind `json:"kind"`
+	Extended bool                     `json:"extended"`
+	IssuedAt time.Time                `json:"issued_at"`
+}
+
+// DeviceRepository stores the device records of a TokenServer.
+type DeviceRepository interface {
+	// Create stores a new device record, or returns ErrDeviceExists.
+	Create(ctx context.Context, record *DeviceRecord) error
+	// Get returns a copy of a device record, or ErrDeviceNotFound.
+	Get(ctx context.Context, serial string) (*DeviceRecord, error)
+	// Update calls update with a copy of a device record and stores it if update returns no error.
+	// The updates of a device are serialised, so two updates never start from the same record.
+	Update(ctx context.Context, serial string, update func(record *DeviceRecord) error) error
+	// List returns a copy of all the device records, sorted by serial number.
+	List(ctx context.Context) ([]*DeviceRecord, error)
+}
+
+// clone returns a copy of the record that does not share its history.
+func (r *DeviceRecord) clone() *DeviceRecord {
+	record := *r
+	record.History = append(make([]TokenRecord, 0, len(r.History)), r.History...)
+	if r.Chain != nil {
+		chain := *r.Chain
+		chain.Checkpoints = copyCheckpoints(r.Chain.Checkpoints)
+		chain.ExtendedCheckpoints = copyCheckpoints(r.Chain.ExtendedCheckpoints)
+		record.Chain = &chain
+	}
+	return &record
+}
+
+// tokenChain returns the chain generating the tokens of the device, created on the first token.
+func (r *DeviceRecord) tokenChain() *openpaygotoken.TokenChain {
+	if r.Chain == nil || r.Chain.StartingCode != r.StartingCode {
+		r.Chain = openpaygotoken.NewTokenChain(r.StartingCode, &r.Key)
+	}
+	r.Chain.SetKey(&r.Key)
+	return r.Chain
+}
+
+// copyCheckpoints returns a copy of the checkpoints of a chain.
+func copyCheckpoints(checkpoints map[int]openpaygotoken.ChainCheckpoint) map[int]openpaygotoken.ChainCheckpoint {
+	copied := make(map[int]openpaygotoken.ChainCheckpoint, len(checkpoints))
+	for base, checkpoint := range checkpoints {
+		copied[base] = checkpoint
+	}
+	return copied
+}
@@ -0,0 +1,274 @@
+package tokenserver
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+)
+
+// maxStartingCode is the largest starting code, the codes of standard tokens have 9 digits.
+const maxStartingCode = 999999999
+
+// DefaultMaxBundleTokens is the default number of tokens a TokenServer issues for a single activation.
+const DefaultMaxBundleTokens = 100
+
+// TokenServer issues the tokens of a fleet of devices kept in a DeviceRepository.
+// The tokens of a device are issued inside an update of its record, so concurrent requests for the same device
+// never issue the same count.
+type TokenServer struct {
+	Repository      DeviceRepository
+	Clock           openpaygotoken.Clock
+	DecoderConfig   openpaygotoken.DecoderConfig // The windows of the device decoders, used to decide on counter syncs
+	MaxBundleTokens int                          // The most tokens issued for a single activation, larger values return ErrInvalidValue
+}
+
+// NewTokenServer creates a new TokenServer.
+func NewTokenServer(repository DeviceRepository) *TokenServer {
+	return NewTokenServerWithClock(openpaygotoken.RealClock{}, repository)
+}
+
+// NewTokenServerWithClock creates a new TokenServer reading the time from the given clock.
+func NewTokenServerWithClock(clock openpaygotoken.Clock, repository DeviceRepository) *TokenServer {
+	return &TokenServer{
+		Repository:      repository,
+		Clock:           clock,
+		DecoderConfig:   openpaygotoken.OpenPAYGODecoderConfig(),
+		MaxBundleTokens: DefaultMaxBundleTokens,
+	}
+}
+
+// RegisterDevice adds a new device to the fleet, with PAYG enabled and no activation.
+// The serial number, count, key, starting code and time divider are taken from the record. The starting code must
+// have at most 9 digits and the time divider must be at least 1, or ErrInvalidDevice is returned.
+func (s *TokenServer) RegisterDevice(ctx context.Context, device *DeviceRecord) (*DeviceRecord, error) {
+	if device.Serial == "" {
+		return nil, &ErrInvalidDevice{Field: "serial"}
+	}
+	if device.StartingCode < 0 || device.StartingCode > maxStartingCode {
+		return nil, &ErrInvalidDevice{Field: "starting_code"}
+	}
+	if device.TimeDivider < 1 {
+		return nil, &ErrInvalidDevice{Field: "time_divider"}
+	}
+	if device.Count < 0 {
+		return nil, &ErrInvalidDevice{Field: "count"}
+	}
+	now := s.now()
+	record := &DeviceRecord{
+		Serial:                 device.Serial,
+		StartingCode:           device.StartingCode,
+		Key:                    device.Key,
+		TimeDivider:            device.TimeDivider,
+		RestrictedDigitSet:     device.RestrictedDigitSet,
+		Count:                  device.Count,
+		ExpirationDate:         now,
+		FurthestExpirationDate: now,
+		PaygEnabled:            true,
+		History:                make([]TokenRecord, 0),
+	}
+	if err := s.Repository.Create(ctx, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// GetDevice returns the record of a device.
+func (s *TokenServer) GetDevice(ctx context.Context, serial string) (*DeviceRecord, error) {
+	return s.Repository.Get(ctx, serial)
+}
+
+// ListDevices returns the records of all the devices.
+func (s *TokenServer) ListDevices(ctx context.Context) ([]*DeviceRecord, error) {
+	return s.Repository.List(ctx)
+}
+
+// GenerateTokensFromValue issues the tokens activating a value for a device.
+// The value is split by SplitActivationValue in at most MaxBundleTokens tokens. With SetTime, the first token sets
+// the time and the next ones add time. The tokens must be entered in the returned order.
+func (s *TokenServer) GenerateTokensFromValue(ctx context.Context, serial string, value int, mode openpaygotoken.TokenType) ([]TokenRecord, error) {
+	if value < 0 {
+		return nil, &ErrInvalidValue{Value: value}
+	}
+	return s.issue(ctx, serial, func(record *DeviceRecord, now time.Time) error {
+		if err := s.generateStandardTokens(record, value, mode, now); err != nil {
+			return err
+		}
+		if record.ExpirationDate.After(record.FurthestExpirationDate) {
+			record.FurthestExpirationDate = record.ExpirationDate
+		}
+		return nil
+	})
+}
+
+// GenerateTokensFromDate issues the tokens setting the expiration date of a device.
+// If the date is after any date issued before, the tokens add time, otherwise they set the time from now.
+// The tokens must be entered in the returned order.
+func (s *TokenServer) GenerateTokensFromDate(ctx context.Context, serial string, expirationDate time.Time) ([]TokenRecord, error) {
+	return s.issue(ctx, serial, func(record *DeviceRecord, now time.Time) error {
+		mode, referenceDate := openpaygotoken.SetTime, now
+		if expirationDate.After(record.FurthestExpirationDate) {
+			mode, referenceDate = openpaygotoken.AddTime, record.ExpirationDate
+			record.FurthestExpirationDate = expirationDate
+		}
+		value := valueToActivate(expirationDate, referenceDate, record.TimeDivider)
+		if err := s.generateStandardTokens(record, value, mode, now); err != nil {
+			return err
+		}
+		record.ExpirationDate = expirationDate
+		return nil
+	})
+}
+
+// GeneratePaygDisableToken issues the token disabling PAYG on a device.
+func (s *TokenServer) GeneratePaygDisableToken(ctx context.Context, serial string) (*TokenRecord, error) {
+	tokens, err := s.issue(ctx, serial, func(record *DeviceRecord, now time.Time) error {
+		return record.generateStandardToken(openpaygotoken.PAYGDisableValue, openpaygotoken.SetTime, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &tokens[0], nil
+}
+
+// GenerateExtendedTokenFromValue issues an extended token adding a value of up to MaxExtendedActivationValue.
+func (s *TokenServer) GenerateExtendedTokenFromValue(ctx context.Context, serial string, value int) (*TokenRecord, error) {
+	if value < 0 || value > openpaygotoken.MaxExtendedActivationValue {
+		return nil, &ErrInvalidValue{Value: value}
+	}
+	tokens, err := s.issue(ctx, serial, func(record *DeviceRecord, now time.Time) error {
+		count, token, err := record.tokenChain().GenerateExtendedToken(value, record.ExtendedCount, record.RestrictedDigitSet)
+		if err != nil {
+			return err
+		}
+		record.ExtendedCount = count
+		record.ExpirationDate = record.ExpirationDate.Add(time.Duration(value/record.TimeDivider) * 24 * time.Hour)
+		if record.ExpirationDate.After(record.FurthestExpirationDate) {
+			record.FurthestExpirationDate = record.ExpirationDate
+		}
+		record.History = append(record.History, TokenRecord{
+			Token:    token,
+			Count:    count,
+			Value:    value,
+			Kind:     openpaygotoken.KindAddTime,
+			Extended: true,
+			IssuedAt: now,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &tokens[0], nil
+}
+
+// SyncCounter synchronises a device with the count it reports.
+// If the device is ahead, the server count moves forward to it and no token is returned. If the next token of the
+// server would be too far above the device count, a counter sync token is returned. Otherwise no token is returned.
+// If the device is too far behind for a counter sync token, ErrCounterSyncOutOfReach is returned.
+func (s *TokenServer) SyncCounter(ctx context.Context, serial string, deviceCount int) (*TokenRecord, error) {
+	tokens, err := s.issue(ctx, serial, func(record *DeviceRecord, now time.Time) error {
+		if deviceCount > record.Count {
+			record.Count = deviceCount
+			return nil
+		}
+		if needed, err := s.DecoderConfig.CounterSyncNeeded(record.Count, deviceCount); !needed || err != nil {
+			return err
+		}
+		return record.generateStandardToken(openpaygotoken.CounterSyncValue, openpaygotoken.AddTime, now)
+	})
+	if err != nil || len(tokens) == 0 {
+		return nil, err
+	}
+	return &tokens[0], nil
+}
+
+// issue updates the record of a device with the given function and returns the tokens it added to the history.
+func (s *TokenServer) issue(ctx context.Context, serial string, update func(record *DeviceRecord, now time.Time) error) ([]TokenRecord, error) {
+	var tokens []TokenRecord
+	err := s.Repository.Update(ctx, serial, func(record *DeviceRecord) error {
+		issued := len(record.History)
+		if err := update(record, s.now()); err != nil {
+			return err
+		}
+		tokens = append(make([]TokenRecord, 0, len(record.History)-issued), record.History[issued:]...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// now returns the time of the clock of the server.
+func (s *TokenServer) now() time.Time {
+	return openpaygotoken.NowFrom(s.Clock)
+}
+
+// generateStandardTokens adds the tokens activating a value to the history of a record.
+// If the value needs more than MaxBundleTokens tokens, ErrInvalidValue is returned.
+func (s *TokenServer) generateStandardTokens(record *DeviceRecord, value int, mode openpaygotoken.TokenType, now time.Time) error {
+	maxTokens := s.MaxBundleTokens
+	if maxTokens < 1 {
+		maxTokens = DefaultMaxBundleTokens
+	}
+	if openpaygotoken.ActivationTokenCount(value, record.TimeDivider) > maxTokens {
+		return &ErrInvalidValue{Value: value}
+	}
+	for _, tokenValue := range openpaygotoken.SplitActivationValue(value, record.TimeDivider) {
+		if err := record.generateStandardToken(tokenValue, mode, now); err != nil {
+			return err
+		}
+		mode = openpaygotoken.AddTime
+	}
+	return nil
+}
+
+// generateStandardToken adds a standard token to the history and updates the record as the device will.
+func (r *DeviceRecord) generateStandardToken(value int, mode openpaygotoken.TokenType, now time.Time) error {
+	count, token, err := r.tokenChain().GenerateStandardToken(value, r.Count, mode, r.RestrictedDigitSet)
+	if err != nil {
+		return err
+	}
+	r.Count = count
+	if value <= openpaygotoken.MaxActivationValue {
+		if mode == openpaygotoken.SetTime {
+			r.PaygEnabled = true
+		}
+		activation := time.Duration(value/r.TimeDivider) * 24 * time.Hour
+		if mode == openpaygotoken.SetTime {
+			r.ExpirationDate = now.Add(activation)
+		} else {
+			r.ExpirationDate = r.ExpirationDate.Add(activation)
+		}
+	} else if value == openpaygotoken.PAYGDisableValue {
+		r.PaygEnabled = false
+	}
+	kind := openpaygotoken.KindAddTime
+	switch {
+	case value == openpaygotoken.CounterSyncValue:
+		kind = openpaygotoken.KindCounterSync
+	case value == openpaygotoken.PAYGDisableValue:
+		kind = openpaygotoken.KindDisable
+	case mode == openpaygotoken.SetTime:
+		kind = openpaygotoken.KindSetTime
+	}
+	r.History = append(r.History, TokenRecord{
+		Token:    token,
+		Count:    count,
+		Value:    value,
+		Kind:     kind,
+		IssuedAt: now,
+	})
+	return nil
+}
+
+// valueToActivate returns the value activating the device from the reference date to the new date.
+func valueToActivate(newDate time.Time, referenceDate time.Time, timeDivider int) int {
+	if !newDate.After(referenceDate) {
+		return 0
+	}
+	days := math.Round(newDate.Sub(referenceDate).Hours() / 24)
+	return int(days) * timeDivider
+}
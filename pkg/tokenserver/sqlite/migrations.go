@@ -0,0 +1,30 @@
+package sqlite
+
+// migrations are the statements creating the schema, in order. The schema version of a database is the number of
+// migrations applied to it, kept in its user_version. A migration must never change once released.
+var migrations = []string{
+	`CREATE TABLE devices (
+		serial                   TEXT PRIMARY KEY,
+		starting_code            INTEGER NOT NULL,
+		key                      BLOB NOT NULL,
+		time_divider             INTEGER NOT NULL,
+		restricted_digit_set     INTEGER NOT NULL,
+		count                    INTEGER NOT NULL,
+		extended_count           INTEGER NOT NULL,
+		expiration_date          TEXT NOT NULL,
+		furthest_expiration_date TEXT NOT NULL,
+		payg_enabled             INTEGER NOT NULL
+	)`,
+	`CREATE TABLE tokens (
+		id        INTEGER PRIMARY KEY AUTOINCREMENT,
+		serial    TEXT NOT NULL REFERENCES devices (serial),
+		token     TEXT NOT NULL,
+		count     INTEGER NOT NULL,
+		value     INTEGER NOT NULL,
+		kind      INTEGER NOT NULL,
+		extended  INTEGER NOT NULL,
+		issued_at TEXT NOT NULL
+	);
+	CREATE INDEX tokens_serial ON tokens (serial, id)`,
+	`ALTER TABLE devices ADD COLUMN chain TEXT NOT NULL DEFAULT ''`,
+}
@@ -0,0 +1,293 @@
+// Package sqlite is a tokenserver.DeviceRepository stored in an embedded SQLite database.
+// The repository requires cgo. Built with CGO_ENABLED=0, the package still compiles so the commands importing it
+// build, but Open returns the error of the SQLite driver.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // The driver fails to open databases when built without cgo
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+	"github.com/wan5xp/openpaygotoken/pkg/tokenserver"
+)
+
+// ErrUnsupportedSchema is returned when the database was migrated by a newer version of the repository.
+type ErrUnsupportedSchema struct {
+	Version int
+}
+
+func (e *ErrUnsupportedSchema) Error() string {
+	return fmt.Sprintf("Unsupported database schema version %d", e.Version)
+}
+
+func (e *ErrUnsupportedSchema) Is(target error) bool {
+	_, ok := target.(*ErrUnsupportedSchema)
+	return ok
+}
+
+// uriPathEscaper escapes the characters of a path that have a meaning in a SQLite URI.
+var uriPathEscaper = strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23")
+
+// Repository is a tokenserver.DeviceRepository stored in a SQLite database.
+// Each update runs in an immediate transaction, so the updates of a device are serialised across connections and
+// processes, and the tokens issued by a failed update are never recorded.
+type Repository struct {
+	db *sql.DB
+}
+
+// Open opens the SQLite database at the given path, creating it if needed, and migrates its schema.
+func Open(path string) (*Repository, error) {
+	dsn := "file:" + uriPathEscaper.Replace(path) + "?_txlock=immediate&_foreign_keys=on&_busy_timeout=5000"
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	repository := &Repository{db: db}
+	if err = repository.migrate(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return repository, nil
+}
+
+// Close closes the database.
+func (r *Repository) Close() error {
+	return r.db.Close()
+}
+
+// SchemaVersion returns the number of migrations applied to the database.
+func (r *Repository) SchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := r.db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+// Create inserts a new device record with its history.
+func (r *Repository) Create(ctx context.Context, record *tokenserver.DeviceRecord) error {
+	chain, err := formatChain(record.Chain)
+	if err != nil {
+		return err
+	}
+	return r.transaction(ctx, func(tx *sql.Tx) error {
+		var exists bool
+		err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM devices WHERE serial = ?)", record.Serial).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return &tokenserver.ErrDeviceExists{Serial: record.Serial}
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO devices (serial, starting_code, key, time_divider, restricted_digit_set,
+			count, extended_count, expiration_date, furthest_expiration_date, payg_enabled, chain) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			record.Serial, record.StartingCode, record.Key[:], record.TimeDivider, record.RestrictedDigitSet, record.Count,
+			record.ExtendedCount, formatTime(record.ExpirationDate), formatTime(record.FurthestExpirationDate), record.PaygEnabled, chain)
+		if err != nil {
+			return err
+		}
+		return insertTokens(ctx, tx, record.Serial, record.History)
+	})
+}
+
+// Get returns a device record with its history.
+func (r *Repository) Get(ctx context.Context, serial string) (*tokenserver.DeviceRecord, error) {
+	var record *tokenserver.DeviceRecord
+	err := r.transaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		record, err = selectDevice(ctx, tx, serial)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Update calls update with a device record inside a transaction, then saves the record and the new tokens of its
+// history. The history is append only, the tokens already recorded are not changed.
+func (r *Repository) Update(ctx context.Context, serial string, update func(record *tokenserver.DeviceRecord) error) error {
+	return r.transaction(ctx, func(tx *sql.Tx) error {
+		record, err := selectDevice(ctx, tx, serial)
+		if err != nil {
+			return err
+		}
+		recorded := len(record.History)
+		if err = update(record); err != nil {
+			return err
+		}
+		chain, err := formatChain(record.Chain)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE devices SET starting_code = ?, key = ?, time_divider = ?, restricted_digit_set = ?,
+			count = ?, extended_count = ?, expiration_date = ?, furthest_expiration_date = ?, payg_enabled = ?, chain = ? WHERE serial = ?`,
+			record.StartingCode, record.Key[:], record.TimeDivider, record.RestrictedDigitSet, record.Count, record.ExtendedCount,
+			formatTime(record.ExpirationDate), formatTime(record.FurthestExpirationDate), record.PaygEnabled, chain, serial)
+		if err != nil {
+			return err
+		}
+		if len(record.History) > recorded {
+			return insertTokens(ctx, tx, serial, record.History[recorded:])
+		}
+		return nil
+	})
+}
+
+// List returns all the device records with their history, sorted by serial number.
+func (r *Repository) List(ctx context.Context) ([]*tokenserver.DeviceRecord, error) {
+	records := make([]*tokenserver.DeviceRecord, 0)
+	err := r.transaction(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, "SELECT serial FROM devices ORDER BY serial")
+		if err != nil {
+			return err
+		}
+		serials := make([]string, 0)
+		for rows.Next() {
+			var serial string
+			if err = rows.Scan(&serial); err != nil {
+				rows.Close()
+				return err
+			}
+			serials = append(serials, serial)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		for _, serial := range serials {
+			record, err := selectDevice(ctx, tx, serial)
+			if err != nil {
+				return err
+			}
+			records = append(records, record)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// migrate applies the migrations the database is missing, each in its own transaction.
+func (r *Repository) migrate(ctx context.Context) error {
+	version, err := r.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return &ErrUnsupportedSchema{Version: version}
+	}
+	for ; version < len(migrations); version++ {
+		err = r.transaction(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, migrations[version]); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", version+1))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transaction runs a function in a transaction, committed if it returns no error.
+func (r *Repository) transaction(ctx context.Context, run func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = run(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// selectDevice returns a device record with its history.
+func selectDevice(ctx context.Context, tx *sql.Tx, serial string) (*tokenserver.DeviceRecord, error) {
+	record := &tokenserver.DeviceRecord{Serial: serial, History: make([]tokenserver.TokenRecord, 0)}
+	var key []byte
+	var expirationDate, furthestExpirationDate, chain string
+	err := tx.QueryRowContext(ctx, `SELECT starting_code, key, time_divider, restricted_digit_set, count, extended_count,
+		expiration_date, furthest_expiration_date, payg_enabled, chain FROM devices WHERE serial = ?`, serial).Scan(
+		&record.StartingCode, &key, &record.TimeDivider, &record.RestrictedDigitSet, &record.Count, &record.ExtendedCount,
+		&expirationDate, &furthestExpirationDate, &record.PaygEnabled, &chain)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &tokenserver.ErrDeviceNotFound{Serial: serial}
+	}
+	if err != nil {
+		return nil, err
+	}
+	copy(record.Key[:], key)
+	if record.ExpirationDate, err = parseTime(expirationDate); err != nil {
+		return nil, err
+	}
+	if record.FurthestExpirationDate, err = parseTime(furthestExpirationDate); err != nil {
+		return nil, err
+	}
+	if chain != "" {
+		record.Chain = &openpaygotoken.TokenChain{}
+		if err = json.Unmarshal([]byte(chain), record.Chain); err != nil {
+			return nil, err
+		}
+		record.Chain.SetKey(&record.Key)
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT token, count, value, kind, extended, issued_at FROM tokens WHERE serial = ? ORDER BY id", serial)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var token tokenserver.TokenRecord
+		var kind int
+		var issuedAt string
+		if err = rows.Scan(&token.Token, &token.Count, &token.Value, &kind, &token.Extended, &issuedAt); err != nil {
+			return nil, err
+		}
+		token.Kind = openpaygotoken.TokenKind(kind)
+		if token.IssuedAt, err = parseTime(issuedAt); err != nil {
+			return nil, err
+		}
+		record.History = append(record.History, token)
+	}
+	return record, rows.Err()
+}
+
+// insertTokens adds tokens to the history of a device.
+func insertTokens(ctx context.Context, tx *sql.Tx, serial string, tokens []tokenserver.TokenRecord) error {
+	for _, token := range tokens {
+		_, err := tx.ExecContext(ctx, "INSERT INTO tokens (serial, token, count, value, kind, extended, issued_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			serial, token.Token, token.Count, token.Value, int(token.Kind), token.Extended, formatTime(token.IssuedAt))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatChain returns the text stored for a token chain, empty if the device has none yet.
+func formatChain(chain *openpaygotoken.TokenChain) (string, error) {
+	if chain == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(chain)
+	return string(data), err
+}
+
+// formatTime returns the text stored for a time.
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// parseTime returns the time of a stored text.
+func parseTime(text string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, text)
+}
@@ -0,0 +1,186 @@
+package openpaygotoken_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+)
+
+func TestTokenChainMatchesGenerators(t *testing.T) {
+	chain := openpaygotoken.NewTokenChain(startingCode, &key)
+	count, extendedCount := 0, 0
+	for xn := 0; xn < 120; xn++ {
+		value := []int{1, 7, 30, openpaygotoken.PAYGDisableValue}[xn%4]
+		mode := openpaygotoken.TokenType(xn%3%2 + 1)
+		restricted := xn%5 == 0
+		expectedCount, expectedToken, err := openpaygotoken.GenerateStandardToken(startingCode, &key, value, count, mode, restricted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		newCount, token, err := chain.GenerateStandardToken(value, count, mode, restricted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if newCount != expectedCount || token != expectedToken {
+			t.Fatalf("Expected count %d and token %s, got %d and %s", expectedCount, expectedToken, newCount, token)
+		}
+		count = newCount
+
+		expectedCount, expectedToken, err = openpaygotoken.GenerateExtendedToken(startingCode, &key, 1000+value, extendedCount, restricted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		newCount, token, err = chain.GenerateExtendedToken(1000+value, extendedCount, restricted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if newCount != expectedCount || token != expectedToken {
+			t.Fatalf("Expected extended count %d and token %s, got %d and %s", expectedCount, expectedToken, newCount, token)
+		}
+		extendedCount = newCount
+	}
+	checkpoints := fmt.Sprint(chain.Checkpoints)
+	_, expectedToken, _ := openpaygotoken.GenerateStandardToken(startingCode, &key, 7, 3, openpaygotoken.AddTime, false)
+	_, token, err := chain.GenerateStandardToken(7, 3, openpaygotoken.AddTime, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != expectedToken {
+		t.Errorf("Expected token before the checkpoint to be %s, got %s", expectedToken, token)
+	}
+	if fmt.Sprint(chain.Checkpoints) != checkpoints {
+		t.Errorf("Expected the checkpoints to be kept, got %v instead of %v", chain.Checkpoints, checkpoints)
+	}
+}
+
+func TestTokenChainSerialization(t *testing.T) {
+	chain := openpaygotoken.NewTokenChain(startingCode, &key)
+	chain.MaxCheckpoints = 2
+	count := 0
+	for value := 1; value <= 3; value++ {
+		var err error
+		count, _, err = chain.GenerateStandardToken(value, count, openpaygotoken.AddTime, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(chain.Checkpoints) != 2 {
+		t.Errorf("Expected 2 checkpoints, got %d", len(chain.Checkpoints))
+	}
+	data, err := json.Marshal(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := &openpaygotoken.TokenChain{}
+	if err = json.Unmarshal(data, loaded); err != nil {
+		t.Fatal(err)
+	}
+	loaded.SetKey(&key)
+	_, expectedToken, _ := openpaygotoken.GenerateStandardToken(startingCode, &key, 3, count, openpaygotoken.AddTime, false)
+	_, token, err := loaded.GenerateStandardToken(3, count, openpaygotoken.AddTime, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != expectedToken {
+		t.Errorf("Expected token to be %s, got %s", expectedToken, token)
+	}
+}
+
+func TestDecodeWithChainMatchesDecode(t *testing.T) {
+	decoder, err := openpaygotoken.NewDecoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := openpaygotoken.NewTokenChain(startingCode, &key)
+	tokens, counts := make([]*openpaygotoken.Token, 0), make([]int, 0)
+	count := 0
+	for xn := 0; xn < 150; xn++ {
+		var tokenString string
+		count, tokenString, err = openpaygotoken.GenerateStandardToken(startingCode, &key, xn%3+1, count, openpaygotoken.TokenType(xn%4/3+1), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := openpaygotoken.ParseToken(tokenString)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens, counts = append(tokens, token), append(counts, count)
+	}
+	lastCount, usedCounts := 0, make([]int, 0)
+	for xn := range tokens {
+		// We enter the tokens in steps, going back to some of the older ones
+		index := xn
+		if xn%7 == 3 {
+			index = xn - 3
+		}
+		expected, expectedErr := decoder.Decode(tokens[index], startingCode, &key, lastCount, &usedCounts)
+		result, err := decoder.DecodeWithChain(tokens[index], chain, lastCount, &usedCounts)
+		if (expectedErr == nil) != (err == nil) || expectedErr != nil && !errors.Is(err, expectedErr) {
+			t.Fatalf("Token %d: expected error %v, got %v", index, expectedErr, err)
+		}
+		if err != nil {
+			continue
+		}
+		if *result != *expected {
+			t.Fatalf("Token %d: expected %+v, got %+v", index, expected, result)
+		}
+		usedCounts = decoder.UpdateUsedCounts(&usedCounts, result.Value, result.Count, result.Type)
+		if result.Count > lastCount {
+			lastCount = result.Count
+		}
+	}
+	if len(chain.Checkpoints) == 0 {
+		t.Errorf("Expected the chain to store checkpoints")
+	}
+	// We enter older tokens again: the ones just below the windows are still told apart from invalid tokens, the ones
+	// below the checkpoints are not searched
+	config := decoder.Config()
+	lowestValidCount := lastCount - config.CounterSyncLookback
+	for index := range tokens {
+		_, err := decoder.DecodeWithChain(tokens[index], chain, lastCount, &usedCounts)
+		switch {
+		case counts[index] < lowestValidCount-2*config.MaxUnusedOlderToken:
+			if !errors.Is(err, &openpaygotoken.ErrInvalidToken{}) {
+				t.Errorf("Token %d: expected ErrInvalidToken below the checkpoints, got %v", index, err)
+			}
+		case counts[index] >= lowestValidCount-config.MaxUnusedOlderToken && counts[index] < lowestValidCount:
+			if !errors.Is(err, &openpaygotoken.ErrTokenOutOfWindow{}) {
+				t.Errorf("Token %d: expected ErrTokenOutOfWindow, got %v", index, err)
+			}
+		}
+	}
+	if _, err := decoder.DecodeWithChain(&openpaygotoken.Token{Format: openpaygotoken.StandardFormat, Code: 987654321}, chain, lastCount, &usedCounts); !errors.Is(err, &openpaygotoken.ErrInvalidToken{}) {
+		t.Errorf("Expected ErrInvalidToken, got %v", err)
+	}
+}
+
+func TestDeviceChainOlderToken(t *testing.T) {
+	device, err := openpaygotoken.NewDevice(startingCode, &key, 1, false, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device.Chain = openpaygotoken.NewTokenChain(startingCode, &key)
+	tokens := make([]string, 0)
+	count := 1
+	for xn := 0; xn < 40; xn++ {
+		var token string
+		count, token, err = openpaygotoken.GenerateStandardToken(startingCode, &key, 1, count, openpaygotoken.AddTime, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = device.EnterToken(token); err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token)
+	}
+	// The last count is 80 and the checkpoint is at 80 - 30 - 16 = 34
+	if _, err = device.EnterToken(tokens[19]); !errors.Is(err, &openpaygotoken.ErrTokenOutOfWindow{}) {
+		t.Errorf("Expected ErrTokenOutOfWindow just below the window, got %v", err)
+	}
+	if _, err = device.EnterToken(tokens[0]); !errors.Is(err, &openpaygotoken.ErrInvalidToken{}) {
+		t.Errorf("Expected ErrInvalidToken below the checkpoint, got %v", err)
+	}
+}
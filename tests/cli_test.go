@@ -0,0 +1,144 @@
+package openpaygotoken_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/wan5xp/openpaygotoken/internal/cli"
+)
+
+func runCLI(t *testing.T, exitCode int, args ...string) string {
+	var stdout, stderr bytes.Buffer
+	if code := cli.Run(args, &stdout, &stderr); code != exitCode {
+		t.Fatalf("opaygo %s: expected exit code %d, got %d: %s", strings.Join(args, " "), exitCode, code, stderr.String())
+	}
+	return stdout.String()
+}
+
+func TestCLIGenerateAndDecode(t *testing.T) {
+	keyHex := hex.EncodeToString(key[:])
+	var generated struct {
+		Token    string `json:"token"`
+		Count    int    `json:"count"`
+		Kind     string `json:"kind"`
+		Extended bool   `json:"extended"`
+	}
+	var decoded struct {
+		Format     string `json:"format"`
+		Count      int    `json:"count"`
+		Value      int    `json:"value"`
+		Kind       string `json:"kind"`
+		UsedCounts []int  `json:"used_counts"`
+	}
+	cases := []struct {
+		args   []string
+		format string
+		value  int
+		kind   string
+	}{
+		{[]string{"-type", "set", "-value", "30"}, "Standard", 30, "SetTime"},
+		{[]string{"-type", "add", "-value", "7", "-restricted"}, "RestrictedStandard", 7, "AddTime"},
+		{[]string{"-type", "disable"}, "Standard", 998, "Disable"},
+		{[]string{"-type", "sync"}, "Standard", 999, "CounterSync"},
+		{[]string{"-extended", "-value", "123456"}, "Extended", 123456, "AddTime"},
+		{[]string{"-extended", "-value", "5000", "-restricted"}, "RestrictedExtended", 5000, "AddTime"},
+	}
+	for _, c := range cases {
+		args := append([]string{"generate", "-json", "-key", keyHex, "-starting-code", "123456789", "-count", "1"}, c.args...)
+		if err := json.Unmarshal([]byte(runCLI(t, 0, args...)), &generated); err != nil {
+			t.Fatal(err)
+		}
+		output := runCLI(t, 0, "decode", "-json", "-key", keyHex, "-starting-code", "123456789", "-last-count", "1", "-used", "1", generated.Token)
+		if err := json.Unmarshal([]byte(output), &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Format != c.format || decoded.Value != c.value || decoded.Kind != c.kind || decoded.Count != generated.Count {
+			t.Errorf("%v: expected a %s %s token of value %d and count %d, got %+v", c.args, c.format, c.kind, c.value, generated.Count, decoded)
+		}
+		if decoded.UsedCounts[len(decoded.UsedCounts)-1] != generated.Count {
+			t.Errorf("%v: expected the count %d to be used, got %v", c.args, generated.Count, decoded.UsedCounts)
+		}
+	}
+}
+
+func TestCLIHumanOutput(t *testing.T) {
+	keyHex := hex.EncodeToString(key[:])
+	output := runCLI(t, 0, "generate", "-key", keyHex, "-starting-code", "123456789", "-count", "1", "-type", "set", "-value", "30")
+	if !strings.Contains(output, "Token: 586541819\n") || !strings.Contains(output, "Count: 3\n") {
+		t.Errorf("Expected the generated token, got %q", output)
+	}
+	output = runCLI(t, 0, "decode", "-key", keyHex, "-starting-code", "123456789", "312-690-787")
+	if !strings.Contains(output, "Kind:   Disable\n") || !strings.Contains(output, "Used:   0,1,2,3\n") {
+		t.Errorf("Expected the decoded token, got %q", output)
+	}
+}
+
+func TestCLIErrors(t *testing.T) {
+	keyHex := hex.EncodeToString(key[:])
+	runCLI(t, 2)
+	runCLI(t, 2, "refund")
+	runCLI(t, 0, "help")
+	runCLI(t, 0, "generate", "-h")
+	runCLI(t, 2, "generate", "-count", "x")
+	runCLI(t, 2, "decode", "-key", keyHex)
+	runCLI(t, 1, "generate", "-key", "a29a", "-value", "1")
+	runCLI(t, 1, "generate", "-key", keyHex, "-type", "refund")
+	runCLI(t, 1, "generate", "-key", keyHex, "-value", "996")
+	runCLI(t, 1, "generate", "-key", keyHex, "-type", "set", "-extended")
+	runCLI(t, 1, "decode", "-key", keyHex, "-starting-code", "123456789", "987654321")
+	runCLI(t, 1, "decode", "-key", keyHex, "-starting-code", "123456789", "12345")
+	runCLI(t, 2, "decode", "-key", keyHex, "-used", "1,x", "312690787")
+}
+
+func TestCLIInspect(t *testing.T) {
+	keyHex := hex.EncodeToString(key[:])
+	var inspected struct {
+		Reason      string `json:"reason"`
+		Explanation string `json:"explanation"`
+		Match       *struct {
+			Count int    `json:"count"`
+			Kind  string `json:"kind"`
+			Used  bool   `json:"used"`
+		} `json:"match"`
+	}
+	output := runCLI(t, 0, "inspect", "-json", "-key", keyHex, "-starting-code", "123456789", "-last-count", "3", "-used", "0,1,2,3", "312690787")
+	if err := json.Unmarshal([]byte(output), &inspected); err != nil {
+		t.Fatal(err)
+	}
+	if inspected.Reason != "AlreadyUsed" || inspected.Match == nil || inspected.Match.Count != 3 || !inspected.Match.Used || inspected.Explanation == "" {
+		t.Errorf("Expected an already used token, got %s", output)
+	}
+	output = runCLI(t, 0, "inspect", "-key", keyHex, "-starting-code", "123456789", "987654321")
+	if !strings.HasPrefix(output, "Reason: NoMatch\n") {
+		t.Errorf("Expected no match, got %q", output)
+	}
+	runCLI(t, 2, "inspect", "-key", keyHex)
+}
+
+func TestCLIRecover(t *testing.T) {
+	keyHex := hex.EncodeToString(key[:])
+	var recovered struct {
+		Candidates []struct {
+			Token    string `json:"token"`
+			Edit     string `json:"edit"`
+			Position int    `json:"position"`
+			Count    int    `json:"count"`
+		} `json:"candidates"`
+	}
+	output := runCLI(t, 0, "recover", "-json", "-key", keyHex, "-starting-code", "123456789", "-last-count", "1", "-used", "1", "586541891")
+	if err := json.Unmarshal([]byte(output), &recovered); err != nil {
+		t.Fatal(err)
+	}
+	if len(recovered.Candidates) == 0 || recovered.Candidates[0].Token != "586541819" || recovered.Candidates[0].Edit != "Transposition" {
+		t.Errorf("Expected the swapped digits to be recovered, got %s", output)
+	}
+	output = runCLI(t, 0, "recover", "-key", keyHex, "-starting-code", "123456789", "-last-count", "1", "-used", "1", "586541829")
+	if !strings.HasPrefix(output, "1. 586541819 (digit 8 replaced): count 3, value 30, kind SetTime\n") {
+		t.Errorf("Expected the replaced digit to be recovered, got %q", output)
+	}
+	runCLI(t, 1, "recover", "-key", keyHex, "-starting-code", "123456789", "222211113333444")
+	runCLI(t, 1, "recover", "-key", keyHex, "12345")
+}
@@ -0,0 +1,128 @@
+package openpaygotoken_test
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+	"github.com/wan5xp/openpaygotoken/pkg/tokenserver"
+	"github.com/wan5xp/openpaygotoken/pkg/tokenserver/client"
+)
+
+func TestClientRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	handler, clock := newTestHandler()
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	tokenClient := client.New(server.URL)
+
+	device, err := tokenClient.RegisterDevice(ctx, tokenserver.DeviceRequest{
+		Serial:       "A",
+		StartingCode: startingCode,
+		Key:          hex.EncodeToString(key[:]),
+		Count:        1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.Serial != "A" || device.Count != 1 || !device.PaygEnabled {
+		t.Errorf("Expected the registered device, got %+v", device)
+	}
+	deviceSimulator, err := openpaygotoken.NewDeviceWithClock(clock, startingCode, &key, 1, false, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enter := func(tokens ...tokenserver.TokenResponse) {
+		for _, token := range tokens {
+			if _, err := deviceSimulator.EnterToken(token.Token); err != nil {
+				t.Errorf("Expected the device to accept %+v, got %v", token, err)
+			}
+		}
+	}
+
+	tokens, err := tokenClient.SetTime(ctx, "A", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enter(tokens...)
+	if tokens, err = tokenClient.AddTime(ctx, "A", 1000); err != nil || len(tokens) != 2 {
+		t.Fatalf("Expected two add time tokens, got %+v and %v", tokens, err)
+	}
+	enter(tokens...)
+	if tokens, err = tokenClient.SetExpirationDate(ctx, "A", clock.Now().Add(2000*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	enter(tokens...)
+	if !deviceSimulator.ExpirationTimestamp.Equal(clock.Now().Add(2000 * 24 * time.Hour)) {
+		t.Errorf("Expected the device to expire in 2000 days, got %v", deviceSimulator.ExpirationTimestamp)
+	}
+	extended, err := tokenClient.AddExtendedTime(ctx, "A", 5000)
+	if err != nil || !extended.Extended || extended.Value != 5000 {
+		t.Errorf("Expected an extended token, got %+v and %v", extended, err)
+	}
+	token, err := tokenClient.SyncCounter(ctx, "A", deviceSimulator.Count)
+	if err != nil || token != nil {
+		t.Errorf("Expected no counter sync token, got %+v and %v", token, err)
+	}
+	if token, err = tokenClient.DisablePayg(ctx, "A"); err != nil {
+		t.Fatal(err)
+	}
+	enter(*token)
+	if deviceSimulator.PaygEnabled {
+		t.Error("Expected PAYG to be disabled")
+	}
+
+	history, err := tokenClient.GetHistory(ctx, "A")
+	if err != nil || len(history) != 6 {
+		t.Errorf("Expected the six issued tokens, got %+v and %v", history, err)
+	}
+	if device, err = tokenClient.GetDevice(ctx, "A"); err != nil || device.Count != deviceSimulator.Count || device.PaygEnabled {
+		t.Errorf("Expected the server state to match the device, got %+v and %v", device, err)
+	}
+	devices, err := tokenClient.ListDevices(ctx)
+	if err != nil || len(devices) != 1 {
+		t.Errorf("Expected one device, got %+v and %v", devices, err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	ctx := context.Background()
+	handler, _ := newTestHandler()
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	tokenClient := client.New(server.URL + "/")
+
+	var apiError *client.ErrAPI
+	_, err := tokenClient.GetDevice(ctx, "missing/device")
+	if !errors.As(err, &apiError) || apiError.StatusCode != http.StatusNotFound || apiError.Message == "" {
+		t.Errorf("Expected a not found error, got %v", err)
+	}
+	_, err = tokenClient.RegisterDevice(ctx, tokenserver.DeviceRequest{Serial: "A", Key: "00"})
+	if !errors.As(err, &apiError) || apiError.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a bad request error, got %v", err)
+	}
+	if !errors.Is(err, &client.ErrAPI{}) {
+		t.Errorf("Expected ErrAPI, got %v", err)
+	}
+}
+
+func TestClientBearerToken(t *testing.T) {
+	handler, _ := newTestHandler()
+	server := httptest.NewServer(tokenserver.RequireBearerToken("secret", handler))
+	defer server.Close()
+	tokenClient := client.New(server.URL)
+
+	var apiError *client.ErrAPI
+	if _, err := tokenClient.ListDevices(context.Background()); !errors.As(err, &apiError) || apiError.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected an unauthorized error, got %v", err)
+	}
+	tokenClient.Token = "secret"
+	if _, err := tokenClient.ListDevices(context.Background()); err != nil {
+		t.Errorf("Expected the token to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package openpaygotoken_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+	"github.com/wan5xp/openpaygotoken/pkg/simulators"
+)
+
+func TestMultiDayExpiration(t *testing.T) {
+	clock := openpaygotoken.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	deviceSimulator, err := simulators.NewDeviceSimulatorWithClock(clock, startingCode, &key, 1, false, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverSimulator := simulators.NewSingleDeviceServerSimulatorWithClock(clock, startingCode, &key, 1, false, 1)
+
+	thisToken, err := serverSimulator.GenerateTokenFromDate(clock.Now().Add(30*24*time.Hour), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = deviceSimulator.EnterToken(thisToken); err != nil {
+		t.Fatal(err)
+	}
+	if !deviceSimulator.ExpirationTimestamp.Equal(serverSimulator.ExpirationDate) {
+		t.Errorf("Expected expiration to be %s, got %s", serverSimulator.ExpirationDate, deviceSimulator.ExpirationTimestamp)
+	}
+	clock.Advance(29 * 24 * time.Hour)
+	if !deviceSimulator.IsActive() {
+		t.Errorf("Expected device to be active after 29 days")
+	}
+	clock.Advance(24 * time.Hour)
+	if deviceSimulator.IsActive() {
+		t.Errorf("Expected device to be inactive after 30 days")
+	}
+
+	thisToken, err = serverSimulator.GenerateTokenFromDate(clock.Now().Add(7*24*time.Hour), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = deviceSimulator.EnterToken(thisToken); err != nil {
+		t.Fatal(err)
+	}
+	if !deviceSimulator.ExpirationTimestamp.Equal(clock.Now().Add(7 * 24 * time.Hour)) {
+		t.Errorf("Expected expiration in 7 days, got %s", deviceSimulator.ExpirationTimestamp.Sub(clock.Now()))
+	}
+}
+
+func TestLockoutEscalation(t *testing.T) {
+	clock := openpaygotoken.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	deviceSimulator, err := simulators.NewDeviceSimulatorWithClock(clock, startingCode, &key, 1, false, true, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expectedWait := range []time.Duration{2 * time.Minute, 6 * time.Minute, 14 * time.Minute} {
+		if err = deviceSimulator.EnterToken("111111111"); !errors.Is(err, &openpaygotoken.ErrInvalidToken{}) {
+			t.Fatalf("Expected ErrInvalidToken, got %v", err)
+		}
+		if wait := deviceSimulator.TokenEntryBlockedUntil.Sub(clock.Now()); wait != expectedWait {
+			t.Errorf("Expected token entry to be blocked for %s, got %s", expectedWait, wait)
+		}
+		clock.Advance(expectedWait - time.Second)
+		if err = deviceSimulator.EnterToken("111111111"); !errors.Is(err, &simulators.ErrTokenEntryBlocked{}) {
+			t.Errorf("Expected ErrTokenEntryBlocked, got %v", err)
+		}
+		clock.Advance(time.Second)
+	}
+}
@@ -0,0 +1,68 @@
+package openpaygotoken_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+)
+
+func TestNewDecoderOptions(t *testing.T) {
+	decoder, err := openpaygotoken.NewDecoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoder.Config() != openpaygotoken.OpenPAYGODecoderConfig() {
+		t.Errorf("Expected the default configuration to be %+v, got %+v", openpaygotoken.OpenPAYGODecoderConfig(), decoder.Config())
+	}
+	decoder, err = openpaygotoken.NewDecoder(openpaygotoken.WithConfig(openpaygotoken.NoOlderTokenDecoderConfig()), openpaygotoken.WithMaxTokenJump(32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := decoder.Config()
+	if config.MaxTokenJump != 32 || config.MaxUnusedOlderToken != 0 || config.MaxTokenJumpCounterSync != 100 {
+		t.Errorf("Expected options to be applied in order, got %+v", config)
+	}
+}
+
+func TestNewDecoderValidation(t *testing.T) {
+	options := []openpaygotoken.DecoderOption{
+		openpaygotoken.WithMaxTokenJump(0),
+		openpaygotoken.WithMaxTokenJumpCounterSync(10),
+		openpaygotoken.WithMaxUnusedOlderToken(-1),
+		openpaygotoken.WithMaxUnusedOlderToken(openpaygotoken.CountWindowSize),
+		openpaygotoken.WithCounterSyncLookback(-1),
+	}
+	for _, option := range options {
+		if _, err := openpaygotoken.NewDecoder(option); !errors.Is(err, &openpaygotoken.ErrInvalidDecoderConfig{}) {
+			t.Errorf("Expected ErrInvalidDecoderConfig, got %v", err)
+		}
+	}
+}
+
+func TestCounterSyncLookback(t *testing.T) {
+	count, token, err := openpaygotoken.GenerateStandardToken(startingCode, &key, openpaygotoken.CounterSyncValue, 10, openpaygotoken.SetTime, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenInt, _ := strconv.Atoi(token)
+	decoder, err := openpaygotoken.NewDecoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := decoder.DecodeToken(tokenInt, startingCode, &key, count+20, false, &[]int{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Kind != openpaygotoken.KindCounterSync || result.Count != count {
+		t.Errorf("Expected counter sync token with count %d, got %+v", count, result)
+	}
+	decoder, err = openpaygotoken.NewDecoder(openpaygotoken.WithCounterSyncLookback(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = decoder.DecodeToken(tokenInt, startingCode, &key, count+20, false, &[]int{}); !errors.Is(err, &openpaygotoken.ErrTokenOutOfWindow{}) {
+		t.Errorf("Expected ErrTokenOutOfWindow, got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package openpaygotoken_test
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+)
+
+func TestCountWindowFromSlice(t *testing.T) {
+	window := openpaygotoken.CountWindowFromSlice([]int{30, 100, 37, 98})
+	if window.Highest != 100 {
+		t.Errorf("Expected highest count to be 100, got %d", window.Highest)
+	}
+	if !reflect.DeepEqual(window.Slice(), []int{37, 98, 100}) {
+		t.Errorf("Expected counts more than 63 below the highest to be dropped, got %v", window.Slice())
+	}
+	if !window.Contains(98) || window.Contains(99) || window.Contains(30) || window.Contains(101) {
+		t.Errorf("Expected the window to contain only its counts, got %v", window.Slice())
+	}
+	if empty := openpaygotoken.CountWindowFromSlice(nil); len(empty.Slice()) != 0 {
+		t.Errorf("Expected an empty window, got %v", empty.Slice())
+	}
+}
+
+func TestCountWindowJSON(t *testing.T) {
+	window := openpaygotoken.CountWindowFromSlice([]int{-3, 0, 12, 40})
+	data, err := json.Marshal(window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "[-3,0,12,40]" {
+		t.Errorf("Expected [-3,0,12,40], got %s", data)
+	}
+	var loaded openpaygotoken.CountWindow
+	if err = json.Unmarshal([]byte(" [ -3 ,0,\n12, 40 ] "), &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded != window {
+		t.Errorf("Expected %v, got %v", window.Slice(), loaded.Slice())
+	}
+	for _, input := range []string{"null", "[]"} {
+		if err = json.Unmarshal([]byte(input), &loaded); err != nil || loaded != (openpaygotoken.CountWindow{}) {
+			t.Errorf("Expected %s to give an empty window, got %v and %v", input, loaded.Slice(), err)
+		}
+	}
+	for _, input := range []string{"[1,]", "[,]", "[1.5]", `["1"]`, "{}", "[1234567890123456789]"} {
+		if err = loaded.UnmarshalJSON([]byte(input)); !errors.Is(err, &openpaygotoken.ErrInvalidCountWindow{}) {
+			t.Errorf("Expected ErrInvalidCountWindow for %s, got %v", input, err)
+		}
+	}
+}
+
+func TestUpdateCountWindow(t *testing.T) {
+	decoder, err := openpaygotoken.NewDecoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	usedCounts := make([]int, 0)
+	var window openpaygotoken.CountWindow
+	updates := []struct {
+		value     int
+		count     int
+		tokenType openpaygotoken.TokenType
+	}{
+		{1, 3, openpaygotoken.SetTime},
+		{1, 6, openpaygotoken.AddTime},
+		{1, 10, openpaygotoken.AddTime},
+		{1, 8, openpaygotoken.AddTime},
+		{1, 40, openpaygotoken.AddTime},
+		{openpaygotoken.CounterSyncValue, 38, openpaygotoken.AddTime},
+		{1, 200, openpaygotoken.AddTime},
+	}
+	for _, update := range updates {
+		usedCounts = decoder.UpdateUsedCounts(&usedCounts, update.value, update.count, update.tokenType)
+		decoder.UpdateCountWindow(&window, update.value, update.count, update.tokenType)
+		if !reflect.DeepEqual(window.Slice(), usedCounts) {
+			t.Errorf("Expected the window to match the used counts %v, got %v", usedCounts, window.Slice())
+		}
+	}
+}
+
+func TestCountWindowAllocations(t *testing.T) {
+	decoder, err := openpaygotoken.NewDecoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	window := openpaygotoken.CountWindowFromSlice([]int{1, 2, 4})
+	allocations := testing.AllocsPerRun(100, func() {
+		if !window.Contains(2) {
+			t.Fatal("Expected the window to contain 2")
+		}
+		decoder.UpdateCountWindow(&window, 1, 5, openpaygotoken.AddTime)
+	})
+	if allocations != 0 {
+		t.Errorf("Expected no allocation, got %v", allocations)
+	}
+}
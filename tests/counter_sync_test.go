@@ -0,0 +1,71 @@
+package openpaygotoken_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+	"github.com/wan5xp/openpaygotoken/pkg/simulators"
+)
+
+func TestCounterSync(t *testing.T) {
+	deviceSimulator, err := simulators.NewDeviceSimulator(startingCode, &key, 1, false, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverSimulator := simulators.NewSingleDeviceServerSimulator(startingCode, &key, 1, false, 1)
+	// The server issues tokens that never reach the device.
+	for xn := 0; xn < 35; xn++ {
+		if _, err = serverSimulator.GenerateTokenFromValue(1, openpaygotoken.AddTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !serverSimulator.CounterSyncNeeded(deviceSimulator.Count) {
+		t.Fatalf("Expected a counter sync to be needed from count %d to %d", deviceSimulator.Count, serverSimulator.Count)
+	}
+	lostToken, err := serverSimulator.GenerateTokenFromValue(1, openpaygotoken.AddTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = deviceSimulator.EnterToken(lostToken); !errors.Is(err, &openpaygotoken.ErrInvalidToken{}) {
+		t.Fatalf("Expected the token to be out of reach of the device, got %v", err)
+	}
+
+	syncToken, err := serverSimulator.SyncCounter(deviceSimulator.Count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if syncToken == "" {
+		t.Fatal("Expected a counter sync token")
+	}
+	if err = deviceSimulator.EnterToken(syncToken); err != nil {
+		t.Fatal(err)
+	}
+	if deviceSimulator.Count != serverSimulator.Count {
+		t.Errorf("Expected device count to be %d, got %d", serverSimulator.Count, deviceSimulator.Count)
+	}
+	if serverSimulator.CounterSyncNeeded(deviceSimulator.Count) {
+		t.Errorf("Expected no counter sync to be needed after syncing")
+	}
+	token, err := serverSimulator.GenerateTokenFromValue(5, openpaygotoken.AddTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = deviceSimulator.EnterToken(token); err != nil {
+		t.Errorf("Expected the token to be accepted after syncing, got %v", err)
+	}
+}
+
+func TestSyncCounterLimits(t *testing.T) {
+	serverSimulator := simulators.NewSingleDeviceServerSimulator(startingCode, &key, 10, false, 1)
+	if token, err := serverSimulator.SyncCounter(10); err != nil || token != "" {
+		t.Errorf("Expected no counter sync for a device in sync, got %q and %v", token, err)
+	}
+	if token, err := serverSimulator.SyncCounter(20); err != nil || token != "" || serverSimulator.Count != 20 {
+		t.Errorf("Expected the server to move forward to the device count, got %q, %v and count %d", token, err, serverSimulator.Count)
+	}
+	serverSimulator.Count = 200
+	if _, err := serverSimulator.SyncCounter(20); !errors.Is(err, &simulators.ErrCounterSyncOutOfReach{}) {
+		t.Errorf("Expected ErrCounterSyncOutOfReach, got %v", err)
+	}
+}
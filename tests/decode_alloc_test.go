@@ -0,0 +1,111 @@
+package openpaygotoken_test
+
+import (
+	"errors"
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+)
+
+func TestDecodeDigits(t *testing.T) {
+	decoder, err := openpaygotoken.NewDecoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, restricted, err := openpaygotoken.GenerateStandardToken(startingCode, &key, 30, 4, openpaygotoken.AddTime, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, extended, err := openpaygotoken.GenerateExtendedToken(startingCode, &key, 123456, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result openpaygotoken.DecodeResult
+	if err = decoder.DecodeDigits(&result, "312 690 787", startingCode, &key, 0, openpaygotoken.CountWindow{}); err != nil {
+		t.Fatal(err)
+	}
+	if result.Value != openpaygotoken.PAYGDisableValue || result.Count != 3 || result.Type != openpaygotoken.SetTime {
+		t.Errorf("Expected the disable token with count 3, got %+v", result)
+	}
+	if err = decoder.DecodeDigits(&result, restricted, startingCode, &key, 4, openpaygotoken.CountWindow{}); err != nil {
+		t.Fatal(err)
+	}
+	if result.Value != 30 || result.Count != 6 {
+		t.Errorf("Expected value 30 and count 6, got %+v", result)
+	}
+	if err = decoder.DecodeDigits(&result, extended, startingCode, &key, 0, openpaygotoken.CountWindow{}); err != nil {
+		t.Fatal(err)
+	}
+	if result.Value != 123456 || result.Count != 1 {
+		t.Errorf("Expected value 123456 and count 1, got %+v", result)
+	}
+	used := openpaygotoken.CountWindowFromSlice([]int{3})
+	if err = decoder.DecodeDigits(&result, "312690787", startingCode, &key, 3, used); !errors.Is(err, &openpaygotoken.ErrValidOlderToken{}) {
+		t.Errorf("Expected ErrValidOlderToken, got %v", err)
+	}
+	if err = decoder.DecodeDigits(&result, "12345", startingCode, &key, 0, used); !errors.Is(err, &openpaygotoken.ErrInvalidTokenLength{}) {
+		t.Errorf("Expected ErrInvalidTokenLength, got %v", err)
+	}
+}
+
+func TestDecodeDigitsAllocations(t *testing.T) {
+	decoder, err := openpaygotoken.NewDecoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, restricted, err := openpaygotoken.GenerateStandardToken(startingCode, &key, 30, 4, openpaygotoken.AddTime, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, extended, err := openpaygotoken.GenerateExtendedToken(startingCode, &key, 123456, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := []string{"312690787", "312690788", restricted, extended}
+	used := openpaygotoken.CountWindowFromSlice([]int{2})
+	var result openpaygotoken.DecodeResult
+	for _, input := range inputs {
+		allocations := testing.AllocsPerRun(20, func() {
+			err = decoder.DecodeDigits(&result, input, startingCode, &key, 2, used)
+			if err == nil {
+				decoder.UpdateCountWindow(&used, result.Value, result.Count, result.Type)
+			}
+		})
+		if allocations != 0 {
+			t.Errorf("Expected no allocation to decode %s, got %v", input, allocations)
+		}
+		parsed, err := openpaygotoken.ParseToken(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		allocations = testing.AllocsPerRun(20, func() {
+			err = decoder.DecodeInto(&result, parsed, startingCode, &key, 2, used)
+			if err == nil {
+				decoder.UpdateCountWindow(&used, result.Value, result.Count, result.Type)
+			}
+		})
+		if allocations != 0 {
+			t.Errorf("Expected no allocation to decode %s into a result, got %v", input, allocations)
+		}
+	}
+}
+
+func TestDecodePathImports(t *testing.T) {
+	// The firmwares build the core package with TinyGo, it must not import the packages that make the binaries large
+	packages, err := parser.ParseDir(token.NewFileSet(), "../pkg/openpaygotoken", nil, parser.ImportsOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forbidden := map[string]bool{`"fmt"`: true, `"strconv"`: true, `"encoding/json"`: true}
+	for _, pkg := range packages {
+		for name, file := range pkg.Files {
+			for _, spec := range file.Imports {
+				if forbidden[spec.Path.Value] {
+					t.Errorf("Expected %s not to import %s", name, spec.Path.Value)
+				}
+			}
+		}
+	}
+}
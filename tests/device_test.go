@@ -0,0 +1,102 @@
+package openpaygotoken_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+)
+
+func TestDeviceApplyToken(t *testing.T) {
+	device, err := openpaygotoken.NewDevice(startingCode, &key, 1, false, true, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, token, err := openpaygotoken.GenerateStandardToken(startingCode, &key, 7, 1, openpaygotoken.AddTime, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenInt, _ := strconv.Atoi(token)
+	result, err := device.ApplyToken(tokenInt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Value != 7 || result.Count != count || result.Type != openpaygotoken.AddTime {
+		t.Errorf("Expected value 7, count %d and AddTime, got %+v", count, result)
+	}
+	if device.Count != count {
+		t.Errorf("Expected count to be %d, got %d", count, device.Count)
+	}
+	if !device.IsActive() {
+		t.Errorf("Expected device to be active")
+	}
+	_, err = device.ApplyToken(tokenInt)
+	if !errors.Is(err, &openpaygotoken.ErrValidOlderToken{}) {
+		t.Errorf("Expected ErrValidOlderToken, got %v", err)
+	}
+}
+
+func TestDeviceInvalidTokenBlocksEntry(t *testing.T) {
+	device, err := openpaygotoken.NewDevice(startingCode, &key, 1, false, true, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = device.ApplyToken(111111111); !errors.Is(err, &openpaygotoken.ErrInvalidToken{}) {
+		t.Errorf("Expected ErrInvalidToken, got %v", err)
+	}
+	if device.InvalidTokenCount != 1 {
+		t.Errorf("Expected invalid token count to be 1, got %d", device.InvalidTokenCount)
+	}
+	_, token, err := openpaygotoken.GenerateStandardToken(startingCode, &key, 7, 1, openpaygotoken.AddTime, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenInt, _ := strconv.Atoi(token)
+	if _, err = device.ApplyToken(tokenInt); !errors.Is(err, &openpaygotoken.ErrTokenEntryBlocked{}) {
+		t.Errorf("Expected ErrTokenEntryBlocked, got %v", err)
+	}
+}
+
+func TestDeviceInvalidTimeDivider(t *testing.T) {
+	if _, err := openpaygotoken.NewDevice(startingCode, &key, 1, false, false, 0); !errors.Is(err, &openpaygotoken.ErrInvalidTimeDivider{}) {
+		t.Errorf("Expected ErrInvalidTimeDivider, got %v", err)
+	}
+}
+
+func TestDevicePaygDisabledIsActive(t *testing.T) {
+	clock := openpaygotoken.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	device, err := openpaygotoken.NewDeviceWithClock(clock, startingCode, &key, 1, false, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.IsActive() {
+		t.Error("Expected a new device to be inactive")
+	}
+	_, token, err := openpaygotoken.GenerateStandardToken(startingCode, &key, openpaygotoken.PAYGDisableValue, 1, openpaygotoken.SetTime, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = device.EnterToken(token); err != nil {
+		t.Fatal(err)
+	}
+	if device.PaygEnabled || !device.IsActive() {
+		t.Errorf("Expected a device with PAYG disabled to be active, got PAYG enabled %v", device.PaygEnabled)
+	}
+	clock.Advance(365 * 24 * time.Hour)
+	if !device.IsActive() {
+		t.Error("Expected a device with PAYG disabled to stay active")
+	}
+	_, token, err = openpaygotoken.GenerateStandardToken(startingCode, &key, 1, 3, openpaygotoken.SetTime, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = device.EnterToken(token); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(2 * 24 * time.Hour)
+	if !device.PaygEnabled || device.IsActive() {
+		t.Errorf("Expected a set time token to enable PAYG again and expire, got PAYG enabled %v", device.PaygEnabled)
+	}
+}
@@ -0,0 +1,71 @@
+package openpaygotoken_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+)
+
+func TestDiagnose(t *testing.T) {
+	decoder, err := openpaygotoken.NewDecoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	noOlderDecoder, err := openpaygotoken.NewDecoder(openpaygotoken.WithConfig(openpaygotoken.NoOlderTokenDecoderConfig()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	generate := func(value int, count int, mode openpaygotoken.TokenType) string {
+		_, token, err := openpaygotoken.GenerateStandardToken(startingCode, &key, value, count, mode, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	_, extendedToken, err := openpaygotoken.GenerateExtendedToken(startingCode, &key, 5000, 199, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey := key
+	otherKey[0]++
+
+	cases := []struct {
+		name      string
+		decoder   *openpaygotoken.TokenDecoder
+		token     string
+		key       *[16]byte
+		lastCount int
+		used      []int
+		reason    openpaygotoken.DiagnosisReason
+		count     int
+		err       error
+	}{
+		{"accepted", decoder, generate(30, 10, openpaygotoken.AddTime), &key, 10, []int{10}, openpaygotoken.ReasonAccepted, 12, nil},
+		{"malformed", decoder, "12345", &key, 0, nil, openpaygotoken.ReasonMalformed, -1, &openpaygotoken.ErrInvalidTokenLength{}},
+		{"wrong key", decoder, generate(30, 10, openpaygotoken.AddTime), &otherKey, 10, nil, openpaygotoken.ReasonNoMatch, -1, &openpaygotoken.ErrInvalidToken{}},
+		{"too far ahead", decoder, generate(30, 200, openpaygotoken.AddTime), &key, 10, nil, openpaygotoken.ReasonTooFarAhead, 202, &openpaygotoken.ErrInvalidToken{}},
+		{"counter sync too far ahead", decoder, generate(openpaygotoken.CounterSyncValue, 200, openpaygotoken.AddTime), &key, 10, nil, openpaygotoken.ReasonTooFarAhead, 202, &openpaygotoken.ErrInvalidToken{}},
+		{"extended too far ahead", decoder, extendedToken, &key, 10, nil, openpaygotoken.ReasonTooFarAhead, 200, &openpaygotoken.ErrInvalidToken{}},
+		{"already used", decoder, generate(30, 10, openpaygotoken.AddTime), &key, 20, []int{12, 20}, openpaygotoken.ReasonAlreadyUsed, 12, &openpaygotoken.ErrValidOlderToken{}},
+		{"older set time", decoder, generate(30, 10, openpaygotoken.SetTime), &key, 20, []int{20}, openpaygotoken.ReasonOlderSetTime, 11, &openpaygotoken.ErrValidOlderToken{}},
+		{"older tokens disabled", noOlderDecoder, generate(30, 10, openpaygotoken.AddTime), &key, 20, []int{20}, openpaygotoken.ReasonOlderTokensDisabled, 12, &openpaygotoken.ErrTokenOutOfWindow{}},
+		{"older than window", decoder, generate(30, 10, openpaygotoken.AddTime), &key, 40, []int{40}, openpaygotoken.ReasonOlderThanWindow, 12, &openpaygotoken.ErrTokenOutOfWindow{}},
+		{"counter sync too old", decoder, generate(openpaygotoken.CounterSyncValue, 10, openpaygotoken.AddTime), &key, 60, []int{60}, openpaygotoken.ReasonCounterSyncTooOld, 12, &openpaygotoken.ErrTokenOutOfWindow{}},
+	}
+	for _, c := range cases {
+		diagnosis := c.decoder.Diagnose(c.token, startingCode, c.key, c.lastCount, openpaygotoken.CountWindowFromSlice(c.used))
+		if diagnosis.Reason != c.reason {
+			t.Errorf("%s: expected %s, got %s: %s", c.name, c.reason, diagnosis.Reason, diagnosis.Explain())
+		}
+		if c.count < 0 && diagnosis.Match != nil || c.count >= 0 && (diagnosis.Match == nil || diagnosis.Match.Count != c.count) {
+			t.Errorf("%s: expected a match at count %d, got %+v", c.name, c.count, diagnosis.Match)
+		}
+		if c.err == nil && diagnosis.Err != nil || c.err != nil && !errors.Is(diagnosis.Err, c.err) {
+			t.Errorf("%s: expected the decoder error %v, got %v", c.name, c.err, diagnosis.Err)
+		}
+		if diagnosis.Explain() == "" || diagnosis.Explain() == "Unknown diagnosis" {
+			t.Errorf("%s: expected an explanation", c.name)
+		}
+	}
+}
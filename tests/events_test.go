@@ -0,0 +1,69 @@
+package openpaygotoken_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+)
+
+func TestDeviceEvents(t *testing.T) {
+	clock := openpaygotoken.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	device, err := openpaygotoken.NewDeviceWithClock(clock, startingCode, &key, 1, false, true, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []openpaygotoken.DeviceEvent
+	unsubscribe := device.Subscribe(func(event openpaygotoken.DeviceEvent) {
+		events = append(events, event)
+	})
+	_, activation, err := openpaygotoken.GenerateStandardToken(startingCode, &key, 10, 1, openpaygotoken.SetTime, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, disable, err := openpaygotoken.GenerateStandardToken(startingCode, &key, openpaygotoken.PAYGDisableValue, 3, openpaygotoken.SetTime, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device.EnterToken(activation)
+	device.EnterToken(activation)
+	device.EnterToken("111111111")
+	device.EnterToken("12345")
+	clock.Advance(2 * time.Minute)
+	device.EnterToken(disable)
+
+	kinds := make([]openpaygotoken.DeviceEventKind, 0, len(events))
+	for _, event := range events {
+		kinds = append(kinds, event.Kind)
+	}
+	expected := []openpaygotoken.DeviceEventKind{
+		openpaygotoken.EventTokenAccepted,
+		openpaygotoken.EventTokenAlreadyUsed,
+		openpaygotoken.EventTokenRejected,
+		openpaygotoken.EventLockout,
+		openpaygotoken.EventTokenRejected,
+		openpaygotoken.EventTokenAccepted,
+		openpaygotoken.EventPaygDisabled,
+	}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Fatalf("Expected events %v, got %v", expected, kinds)
+	}
+	accepted := events[0]
+	if accepted.Token != activation || accepted.Count != 3 || accepted.Value != 10 || !accepted.ExpirationTimestamp.Equal(clock.Now().Add(-2*time.Minute).Add(10*24*time.Hour)) {
+		t.Errorf("Expected the accepted token event, got %+v", accepted)
+	}
+	if !errors.Is(events[2].Err, &openpaygotoken.ErrInvalidToken{}) || events[3].Wait != 2*time.Minute {
+		t.Errorf("Expected an invalid token then a 2m lockout, got %+v and %+v", events[2], events[3])
+	}
+	if !errors.Is(events[4].Err, &openpaygotoken.ErrInvalidTokenLength{}) {
+		t.Errorf("Expected the malformed token to be rejected, got %+v", events[4])
+	}
+
+	unsubscribe()
+	device.EnterToken("111111111")
+	if len(events) != len(expected) {
+		t.Errorf("Expected no event after unsubscribing, got %d more", len(events)-len(expected))
+	}
+}
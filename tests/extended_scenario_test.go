@@ -0,0 +1,66 @@
+package openpaygotoken_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+	"github.com/wan5xp/openpaygotoken/pkg/simulators"
+)
+
+func TestExtendedScenario(t *testing.T) {
+	for _, restrictedDigitSet := range []bool{false, true} {
+		deviceSimulator, err := simulators.NewDeviceSimulator(startingCode, &key, 1, restrictedDigitSet, false, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serverSimulator := simulators.NewSingleDeviceServerSimulator(startingCode, &key, 1, restrictedDigitSet, 1)
+
+		thisToken, err := serverSimulator.GenerateTokenFromValue(10, openpaygotoken.SetTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = deviceSimulator.EnterToken(thisToken); err != nil {
+			t.Fatal(err)
+		}
+		expiration := deviceSimulator.ExpirationTimestamp
+
+		thisToken, err = serverSimulator.GenerateExtendedTokenFromValue(2000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restrictedDigitSet && len(thisToken) != 20 || !restrictedDigitSet && len(thisToken) != 12 {
+			t.Errorf("Expected an extended token, got %s", thisToken)
+		}
+		if err = deviceSimulator.EnterToken(thisToken); err != nil {
+			t.Fatal(err)
+		}
+		if deviceSimulator.ExtendedCount != serverSimulator.ExtendedCount {
+			t.Errorf("Expected extended count to be %d, got %d", serverSimulator.ExtendedCount, deviceSimulator.ExtendedCount)
+		}
+		if deviceSimulator.Count != serverSimulator.Count {
+			t.Errorf("Expected count to be %d, got %d", serverSimulator.Count, deviceSimulator.Count)
+		}
+		if deviceSimulator.ExpirationTimestamp.Sub(expiration) != 2000*24*time.Hour {
+			t.Errorf("Expected 2000 days to be added, got %s", deviceSimulator.ExpirationTimestamp.Sub(expiration))
+		}
+		if err = deviceSimulator.EnterToken(thisToken); !errors.Is(err, &simulators.ErrOldToken{}) {
+			t.Errorf("Expected ErrOldToken, got %v", err)
+		}
+
+		thisToken, err = serverSimulator.GenerateTokenFromValue(1, openpaygotoken.AddTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = deviceSimulator.EnterToken(thisToken); err != nil {
+			t.Fatal(err)
+		}
+		if deviceSimulator.Count != serverSimulator.Count {
+			t.Errorf("Expected count to be %d, got %d", serverSimulator.Count, deviceSimulator.Count)
+		}
+		if deviceSimulator.ExpirationTimestamp.Sub(expiration) != 2001*24*time.Hour {
+			t.Errorf("Expected 2001 days to be added, got %s", deviceSimulator.ExpirationTimestamp.Sub(expiration))
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package openpaygotoken_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+	"github.com/wan5xp/openpaygotoken/pkg/tokenserver"
+)
+
+func newTestHandler() (http.Handler, openpaygotoken.Clock) {
+	clock := openpaygotoken.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	server := tokenserver.NewTokenServerWithClock(clock, tokenserver.NewMemoryRepository())
+	return tokenserver.NewHandler(server), clock
+}
+
+func serveJSON(t *testing.T, handler http.Handler, method string, path string, body string, status int, response interface{}) {
+	request := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != status {
+		t.Fatalf("%s %s: expected status %d, got %d: %s", method, path, status, recorder.Code, recorder.Body)
+	}
+	if recorder.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("%s %s: expected a JSON response, got %s", method, path, recorder.Header().Get("Content-Type"))
+	}
+	if response != nil {
+		if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHandlerIssueTokens(t *testing.T) {
+	handler, clock := newTestHandler()
+	var device tokenserver.DeviceResponse
+	serveJSON(t, handler, http.MethodPost, "/devices", `{"serial":"A","starting_code":123456789,"key":"a29ab82edc5fbbc41ec9530f6dac86b1","count":1}`,
+		http.StatusCreated, &device)
+	if device.Serial != "A" || device.Count != 1 || device.TimeDivider != 1 || !device.PaygEnabled {
+		t.Errorf("Expected the registered device, got %+v", device)
+	}
+
+	var tokens tokenserver.TokensResponse
+	serveJSON(t, handler, http.MethodPost, "/devices/A/tokens/set-time", `{"value":30}`, http.StatusOK, &tokens)
+	if len(tokens.Tokens) != 1 || tokens.Tokens[0].Kind != "SetTime" || tokens.Tokens[0].Count != 3 {
+		t.Fatalf("Expected a set time token, got %+v", tokens)
+	}
+	deviceSimulator, err := openpaygotoken.NewDeviceWithClock(clock, startingCode, &key, 1, false, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = deviceSimulator.EnterToken(tokens.Tokens[0].Token); err != nil {
+		t.Errorf("Expected the device to accept the token, got %v", err)
+	}
+	serveJSON(t, handler, http.MethodPost, "/devices/A/tokens/add-time", `{"value":1500}`, http.StatusOK, &tokens)
+	if len(tokens.Tokens) != 2 || tokens.Tokens[0].Value != openpaygotoken.MaxActivationValue {
+		t.Errorf("Expected two add time tokens, got %+v", tokens)
+	}
+	serveJSON(t, handler, http.MethodPost, "/devices/A/tokens/disable", "", http.StatusOK, &tokens)
+	if len(tokens.Tokens) != 1 || tokens.Tokens[0].Kind != "Disable" {
+		t.Errorf("Expected a disable token, got %+v", tokens)
+	}
+
+	serveJSON(t, handler, http.MethodGet, "/devices/A", "", http.StatusOK, &device)
+	if device.Count != 7 || device.PaygEnabled {
+		t.Errorf("Expected the device state, got %+v", device)
+	}
+	serveJSON(t, handler, http.MethodGet, "/devices/A/tokens", "", http.StatusOK, &tokens)
+	if len(tokens.Tokens) != 4 {
+		t.Errorf("Expected the four issued tokens, got %+v", tokens)
+	}
+	var devices tokenserver.DevicesResponse
+	serveJSON(t, handler, http.MethodGet, "/devices", "", http.StatusOK, &devices)
+	if len(devices.Devices) != 1 || devices.Devices[0].Serial != "A" {
+		t.Errorf("Expected the device list, got %+v", devices)
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	handler, _ := newTestHandler()
+	device := `{"serial":"A","key":"a29ab82edc5fbbc41ec9530f6dac86b1","count":1}`
+	serveJSON(t, handler, http.MethodPost, "/devices", device, http.StatusCreated, nil)
+	cases := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodPost, "/devices", device, http.StatusConflict},
+		{http.MethodPost, "/devices", `{"serial":"B","key":"a29a"}`, http.StatusBadRequest},
+		{http.MethodPost, "/devices", `{"serial":"B","key":"a29ab82edc5fbbc41ec9530f6dac86b1","color":"red"}`, http.StatusBadRequest},
+		{http.MethodPost, "/devices", `{`, http.StatusBadRequest},
+		{http.MethodGet, "/devices/B", "", http.StatusNotFound},
+		{http.MethodPost, "/devices/B/tokens/add-time", `{"value":7}`, http.StatusNotFound},
+		{http.MethodPost, "/devices/A/tokens/add-time", `{"value":-7}`, http.StatusBadRequest},
+		{http.MethodPost, "/devices/A/tokens/extended", `{"value":1000000}`, http.StatusBadRequest},
+		{http.MethodPost, "/devices/A/tokens/add-time", `{"value":1000000}`, http.StatusBadRequest},
+		{http.MethodPost, "/devices/A/tokens/expiration-date", `{}`, http.StatusBadRequest},
+		{http.MethodPost, "/devices/A/tokens/add-time", "", http.StatusBadRequest},
+		{http.MethodPost, "/devices/A/tokens/set-time", `{}`, http.StatusBadRequest},
+		{http.MethodPost, "/devices/A/tokens/extended", `{}`, http.StatusBadRequest},
+		{http.MethodPost, "/devices/A/tokens/counter-sync", `{}`, http.StatusBadRequest},
+		{http.MethodPost, "/devices", `{"serial":"` + strings.Repeat("A", 2<<20) + `"}`, http.StatusRequestEntityTooLarge},
+		{http.MethodPost, "/devices/A/tokens/refund", `{}`, http.StatusNotFound},
+		{http.MethodDelete, "/devices/A", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/devices/A/tokens/add-time", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/status", "", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		var response tokenserver.ErrorResponse
+		serveJSON(t, handler, c.method, c.path, c.body, c.status, &response)
+		if response.Error == "" {
+			t.Errorf("%s %s: expected an error message", c.method, c.path)
+		}
+	}
+}
+
+func TestHandlerCounterSync(t *testing.T) {
+	handler, _ := newTestHandler()
+	serveJSON(t, handler, http.MethodPost, "/devices", `{"serial":"A","key":"a29ab82edc5fbbc41ec9530f6dac86b1","count":1}`, http.StatusCreated, nil)
+	var tokens tokenserver.TokensResponse
+	serveJSON(t, handler, http.MethodPost, "/devices/A/tokens/counter-sync", `{"device_count":1}`, http.StatusOK, &tokens)
+	if len(tokens.Tokens) != 0 {
+		t.Errorf("Expected no counter sync token, got %+v", tokens)
+	}
+	for i := 0; i < 35; i++ {
+		serveJSON(t, handler, http.MethodPost, "/devices/A/tokens/add-time", `{"value":1}`, http.StatusOK, nil)
+	}
+	serveJSON(t, handler, http.MethodPost, "/devices/A/tokens/counter-sync", `{"device_count":1}`, http.StatusOK, &tokens)
+	if len(tokens.Tokens) != 1 || tokens.Tokens[0].Kind != "CounterSync" {
+		t.Errorf("Expected a counter sync token, got %+v", tokens)
+	}
+	for i := 0; i < 25; i++ {
+		serveJSON(t, handler, http.MethodPost, "/devices/A/tokens/add-time", `{"value":1}`, http.StatusOK, nil)
+	}
+	serveJSON(t, handler, http.MethodPost, "/devices/A/tokens/counter-sync", `{"device_count":1}`, http.StatusConflict, nil)
+}
+
+func TestHandlerBearerToken(t *testing.T) {
+	handler, _ := newTestHandler()
+	handler = tokenserver.RequireBearerToken("secret", handler)
+	serveJSON(t, handler, http.MethodGet, "/devices", "", http.StatusUnauthorized, nil)
+	request := httptest.NewRequest(http.MethodGet, "/devices", nil)
+	request.Header.Set("Authorization", "Bearer wrong")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a wrong token to be rejected, got %d", recorder.Code)
+	}
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected the token to be accepted, got %d: %s", recorder.Code, recorder.Body)
+	}
+}
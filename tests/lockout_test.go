@@ -0,0 +1,70 @@
+package openpaygotoken_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+)
+
+func TestFixedDelayLockout(t *testing.T) {
+	policy := openpaygotoken.FixedDelayLockout{Delay: 5 * time.Minute}
+	state := openpaygotoken.LockedDeviceState(0)
+	now := time.Unix(1700000000, 0)
+	for attempt := 1; attempt <= 3; attempt++ {
+		policy.RegisterInvalidToken(state, now)
+		if wait := policy.RemainingWait(state, now); wait != 5*time.Minute {
+			t.Errorf("Expected a 5m wait after attempt %d, got %s", attempt, wait)
+		}
+		now = now.Add(5 * time.Minute)
+		if wait := policy.RemainingWait(state, now); wait != 0 {
+			t.Errorf("Expected no wait after the delay, got %s", wait)
+		}
+	}
+	if state.InvalidTokenCount != 3 {
+		t.Errorf("Expected 3 invalid tokens, got %d", state.InvalidTokenCount)
+	}
+}
+
+func TestDailyAttemptsLockout(t *testing.T) {
+	policy := openpaygotoken.DailyAttemptsLockout{MaxAttempts: 3}
+	state := openpaygotoken.LockedDeviceState(0)
+	start := time.Unix(1700000000, 0)
+	policy.RegisterInvalidToken(state, start)
+	policy.RegisterInvalidToken(state, start.Add(time.Hour))
+	if wait := policy.RemainingWait(state, start.Add(time.Hour)); wait != 0 {
+		t.Errorf("Expected no wait before the last attempt, got %s", wait)
+	}
+	policy.RegisterInvalidToken(state, start.Add(2*time.Hour))
+	if wait := policy.RemainingWait(state, start.Add(2*time.Hour)); wait != 22*time.Hour {
+		t.Errorf("Expected a wait until the end of the day, got %s", wait)
+	}
+	nextDay := start.Add(25 * time.Hour)
+	policy.RegisterInvalidToken(state, nextDay)
+	if state.InvalidTokenCount != 1 || !state.FirstInvalidTokenAt.Equal(nextDay) || policy.RemainingWait(state, nextDay) != 0 {
+		t.Errorf("Expected a new day of attempts, got %+v", state)
+	}
+}
+
+func TestDeviceLockoutPolicy(t *testing.T) {
+	clock := openpaygotoken.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	device, err := openpaygotoken.NewDeviceWithClock(clock, startingCode, &key, 1, false, true, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device.Lockout = openpaygotoken.FixedDelayLockout{Delay: time.Minute}
+	if _, err = device.EnterToken("111111111"); !errors.Is(err, &openpaygotoken.ErrInvalidToken{}) {
+		t.Fatalf("Expected ErrInvalidToken, got %v", err)
+	}
+	if wait := device.RemainingWait(); wait != time.Minute {
+		t.Errorf("Expected a 1m wait, got %s", wait)
+	}
+	if _, err = device.EnterToken("111111111"); !errors.Is(err, &openpaygotoken.ErrTokenEntryBlocked{}) {
+		t.Errorf("Expected ErrTokenEntryBlocked, got %v", err)
+	}
+	device.WaitingPeriodEnabled = false
+	if wait := device.RemainingWait(); wait != 0 {
+		t.Errorf("Expected no wait with the waiting period disabled, got %s", wait)
+	}
+}
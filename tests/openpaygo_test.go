@@ -1,6 +1,8 @@
 package openpaygotoken_test
 
 import (
+	"errors"
+	"strconv"
 	"testing"
 
 	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
@@ -106,8 +108,8 @@ func TestDecodeExtendedToken(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	if count != 1 {
-		t.Errorf("Expected count to be 1, got %d", count)
+	if count != 2 {
+		t.Errorf("Expected count to be 2, got %d", count)
 	}
 	if value != 1000 {
 		t.Errorf("Expected value to be 1000, got %d", value)
@@ -148,3 +150,97 @@ func TestUpdateUsedCount(t *testing.T) {
 		t.Errorf("Expected usedCount to contain 100 and 98")
 	}
 }
+
+func TestDecodeTokenResult(t *testing.T) {
+	decoder, err := openpaygotoken.NewDecoder()
+	if err != nil {
+		t.Error(err)
+	}
+	var usedCount []int = make([]int, 0)
+	result, err := decoder.DecodeToken(312690787, startingCode, &key, 0, false, &usedCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Count != 3 || result.Value != openpaygotoken.PAYGDisableValue || result.Type != openpaygotoken.SetTime {
+		t.Errorf("Expected count 3, value %d and SetTime, got %+v", openpaygotoken.PAYGDisableValue, result)
+	}
+	if result.Kind != openpaygotoken.KindDisable {
+		t.Errorf("Expected kind to be %s, got %s", openpaygotoken.KindDisable, result.Kind)
+	}
+	if result.OlderToken {
+		t.Errorf("Expected token not to be an older token")
+	}
+}
+
+func TestDecodeTokenErrors(t *testing.T) {
+	decoder, err := openpaygotoken.NewDecoder()
+	if err != nil {
+		t.Error(err)
+	}
+	_, token, err := openpaygotoken.GenerateStandardToken(startingCode, &key, 1, 0, openpaygotoken.AddTime, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenInt, _ := strconv.Atoi(token)
+	usedCount := []int{2}
+	_, err = decoder.DecodeToken(tokenInt, startingCode, &key, 4, false, &usedCount)
+	if !errors.Is(err, &openpaygotoken.ErrValidOlderToken{}) || errors.Is(err, &openpaygotoken.ErrTokenOutOfWindow{}) {
+		t.Errorf("Expected ErrValidOlderToken, got %v", err)
+	}
+	result, err := decoder.DecodeToken(tokenInt, startingCode, &key, 4, false, &[]int{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.OlderToken {
+		t.Errorf("Expected token to be an older token")
+	}
+	_, err = decoder.DecodeToken(tokenInt, startingCode, &key, 40, false, &[]int{})
+	if !errors.Is(err, &openpaygotoken.ErrTokenOutOfWindow{}) || !errors.Is(err, &openpaygotoken.ErrValidOlderToken{}) {
+		t.Errorf("Expected ErrTokenOutOfWindow, got %v", err)
+	}
+	_, err = decoder.DecodeToken(111111111, startingCode, &key, 4, false, &[]int{})
+	if !errors.Is(err, &openpaygotoken.ErrInvalidToken{}) {
+		t.Errorf("Expected ErrInvalidToken, got %v", err)
+	}
+}
+
+func TestDecodeExtendedTokenWindows(t *testing.T) {
+	decoder, err := openpaygotoken.NewDecoder(openpaygotoken.WithMaxTokenJump(10))
+	if err != nil {
+		t.Error(err)
+	}
+	tokens := make([]*openpaygotoken.Token, 0)
+	for count := 0; count < 20; count++ {
+		_, tokenString, err := openpaygotoken.GenerateExtendedToken(startingCode, &key, 5000+count, count, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := openpaygotoken.ParseToken(tokenString)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token)
+	}
+	if _, err = decoder.Decode(tokens[15], startingCode, &key, 1, &[]int{}); !errors.Is(err, &openpaygotoken.ErrInvalidToken{}) {
+		t.Errorf("Expected token beyond the maximum jump to be invalid, got %v", err)
+	}
+	usedCount := make([]int, 0)
+	result, err := decoder.Decode(tokens[9], startingCode, &key, 1, &usedCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Count != 10 || result.Value != 5009 || result.Kind != openpaygotoken.KindAddTime {
+		t.Errorf("Expected count 10, value 5009 and AddTime, got %+v", result)
+	}
+	usedCount = decoder.UpdateExtendedUsedCounts(&usedCount, result.Count)
+	if _, err = decoder.Decode(tokens[9], startingCode, &key, result.Count, &usedCount); !errors.Is(err, &openpaygotoken.ErrValidOlderToken{}) {
+		t.Errorf("Expected ErrValidOlderToken, got %v", err)
+	}
+	result, err = decoder.Decode(tokens[7], startingCode, &key, 10, &usedCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Count != 8 || !result.OlderToken {
+		t.Errorf("Expected older token with count 8, got %+v", result)
+	}
+}
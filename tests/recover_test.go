@@ -0,0 +1,117 @@
+package openpaygotoken_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+)
+
+func TestRecoverToken(t *testing.T) {
+	decoder, err := openpaygotoken.NewDecoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, standardToken, err := openpaygotoken.GenerateStandardToken(startingCode, &key, 30, 10, openpaygotoken.AddTime, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, restrictedToken, err := openpaygotoken.GenerateStandardToken(startingCode, &key, 30, 10, openpaygotoken.AddTime, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := openpaygotoken.CountWindowFromSlice([]int{10})
+	for _, token := range []string{standardToken, restrictedToken} {
+		alphabet := "0123456789"
+		if len(token) == 15 {
+			alphabet = "1234"
+		}
+		typos := make([]string, 0)
+		for position := range token {
+			for i := 0; i < len(alphabet); i++ {
+				if alphabet[i] != token[position] {
+					typos = append(typos, token[:position]+alphabet[i:i+1]+token[position+1:])
+				}
+			}
+			if position+1 < len(token) && token[position] != token[position+1] {
+				typos = append(typos, token[:position]+token[position+1:position+2]+token[position:position+1]+token[position+2:])
+			}
+		}
+		for _, typo := range typos {
+			candidates, err := decoder.RecoverToken(typo, startingCode, &key, 10, used)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(candidates) == 0 || candidates[0].Token != token || candidates[0].Result.Count != 12 || candidates[0].Result.Value != 30 {
+				t.Errorf("Expected %s to be recovered first from %s, got %+v", token, typo, candidates)
+			}
+		}
+	}
+}
+
+func TestRecoverUsedToken(t *testing.T) {
+	decoder, err := openpaygotoken.NewDecoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, token, err := openpaygotoken.GenerateStandardToken(startingCode, &key, 30, 8, openpaygotoken.AddTime, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidates, err := decoder.RecoverToken(token, startingCode, &key, count, openpaygotoken.CountWindowFromSlice([]int{count}))
+	if !errors.Is(err, &openpaygotoken.ErrValidOlderToken{}) || candidates != nil {
+		t.Errorf("Expected ErrValidOlderToken, got %+v and %v", candidates, err)
+	}
+}
+
+func TestRecoverTokenRanking(t *testing.T) {
+	decoder, err := openpaygotoken.NewDecoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidates, err := decoder.RecoverToken("586 541 819", startingCode, &key, 1, openpaygotoken.CountWindowFromSlice([]int{1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0].Edit != openpaygotoken.EditNone {
+		t.Errorf("Expected the valid token as entered, got %+v", candidates)
+	}
+	candidates, err = decoder.RecoverToken("856541819", startingCode, &key, 1, openpaygotoken.CountWindowFromSlice([]int{1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) == 0 || candidates[0].Token != "586541819" || candidates[0].Edit != openpaygotoken.EditTransposition || candidates[0].Position != 0 {
+		t.Errorf("Expected the swapped digits to be recovered, got %+v", candidates)
+	}
+	candidates, err = decoder.RecoverToken("586541829", startingCode, &key, 1, openpaygotoken.CountWindowFromSlice([]int{1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) == 0 || candidates[0].Edit != openpaygotoken.EditSubstitution || candidates[0].Position != 7 {
+		t.Errorf("Expected the replaced digit to be recovered, got %+v", candidates)
+	}
+	gap := func(candidate openpaygotoken.TokenCandidate) int {
+		if candidate.Result.Count < 2 {
+			return 2 - candidate.Result.Count
+		}
+		return candidate.Result.Count - 2
+	}
+	for i := 1; i < len(candidates); i++ {
+		if gap(candidates[i]) < gap(candidates[i-1]) {
+			t.Errorf("Expected the candidates closest to the next count first, got %+v", candidates)
+		}
+	}
+	if candidates, err = decoder.RecoverToken("5865418195", startingCode, &key, 1, openpaygotoken.CountWindow{}); !errors.Is(err, &openpaygotoken.ErrInvalidTokenLength{}) {
+		t.Errorf("Expected ErrInvalidTokenLength, got %+v and %v", candidates, err)
+	}
+
+	if _, err = decoder.RecoverToken("5865418", startingCode, &key, 1, openpaygotoken.CountWindow{}); !errors.Is(err, &openpaygotoken.ErrInvalidTokenLength{}) {
+		t.Errorf("Expected ErrInvalidTokenLength, got %v", err)
+	}
+	if _, err = decoder.RecoverToken("58654181a", startingCode, &key, 1, openpaygotoken.CountWindow{}); !errors.Is(err, &openpaygotoken.ErrInvalidTokenCharacter{}) {
+		t.Errorf("Expected ErrInvalidTokenCharacter, got %v", err)
+	}
+	if candidates, err = decoder.RecoverToken("222211113333444", startingCode, &key, 1, openpaygotoken.CountWindow{}); err != nil || len(candidates) != 0 {
+		t.Errorf("Expected no candidate, got %+v and %v", candidates, err)
+	}
+}
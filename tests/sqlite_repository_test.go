@@ -0,0 +1,107 @@
+//go:build cgo
+
+package openpaygotoken_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+	"github.com/wan5xp/openpaygotoken/pkg/tokenserver"
+	"github.com/wan5xp/openpaygotoken/pkg/tokenserver/sqlite"
+)
+
+func init() {
+	testRepositoryOpeners["sqlite"] = func(t *testing.T) tokenserver.DeviceRepository {
+		return openTestDatabase(t, filepath.Join(t.TempDir(), "devices.db"))
+	}
+}
+
+func openTestDatabase(t *testing.T, path string) *sqlite.Repository {
+	repository, err := sqlite.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		repository.Close()
+	})
+	return repository
+}
+
+func TestSQLiteRepositoryPersistence(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "devices.db")
+	clock := openpaygotoken.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	repository := openTestDatabase(t, path)
+	server := tokenserver.NewTokenServerWithClock(clock, repository)
+	registerTestDevice(t, server, "A")
+	tokens, err := server.GenerateTokensFromValue(ctx, "A", 30, openpaygotoken.SetTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repository.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	repository = openTestDatabase(t, path)
+	version, err := repository.SchemaVersion(ctx)
+	if err != nil || version != 3 {
+		t.Errorf("Expected schema version 3, got %d and %v", version, err)
+	}
+	record, err := repository.Get(ctx, "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Key != key || record.Count != tokens[0].Count || !record.ExpirationDate.Equal(clock.Now().Add(30*24*time.Hour)) {
+		t.Errorf("Expected the record to be saved, got %+v", record)
+	}
+	if len(record.History) != 1 || record.History[0].Token != tokens[0].Token || !record.History[0].IssuedAt.Equal(tokens[0].IssuedAt) {
+		t.Errorf("Expected the history %+v, got %+v", tokens, record.History)
+	}
+}
+
+func TestSQLiteRepositoryRollback(t *testing.T) {
+	ctx := context.Background()
+	repository := openTestDatabase(t, filepath.Join(t.TempDir(), "devices.db"))
+	server := tokenserver.NewTokenServer(repository)
+	registerTestDevice(t, server, "A")
+	failure := errors.New("payment failed")
+	err := repository.Update(ctx, "A", func(record *tokenserver.DeviceRecord) error {
+		record.Count = 100
+		record.History = append(record.History, tokenserver.TokenRecord{Token: "123456789", Count: 100})
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("Expected the update error, got %v", err)
+	}
+	record, err := repository.Get(ctx, "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Count != 1 || len(record.History) != 0 {
+		t.Errorf("Expected the failed update to be rolled back, got %+v", record)
+	}
+	if err = repository.Update(ctx, "B", func(*tokenserver.DeviceRecord) error { return nil }); !errors.Is(err, &tokenserver.ErrDeviceNotFound{}) {
+		t.Errorf("Expected ErrDeviceNotFound, got %v", err)
+	}
+}
+
+func TestSQLiteRepositoryNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.db")
+	openTestDatabase(t, path).Close()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec("PRAGMA user_version = 99"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if _, err = sqlite.Open(path); !errors.Is(err, &sqlite.ErrUnsupportedSchema{}) {
+		t.Errorf("Expected ErrUnsupportedSchema, got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package openpaygotoken_test
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+)
+
+func testDeviceState() *openpaygotoken.DeviceState {
+	return &openpaygotoken.DeviceState{
+		Count:                  42,
+		UsedCounts:             openpaygotoken.CountWindowFromSlice([]int{26, 30, 41, 42}),
+		ExtendedCount:          7,
+		ExtendedUsedCounts:     openpaygotoken.CountWindowFromSlice([]int{5, 7}),
+		PaygEnabled:            true,
+		ExpirationTimestamp:    time.Unix(1700000000, 0),
+		InvalidTokenCount:      3,
+		FirstInvalidTokenAt:    time.Unix(1700000300, 0),
+		TokenEntryBlockedUntil: time.Unix(1700000600, 0),
+	}
+}
+
+func TestDeviceStateBinaryRoundTrip(t *testing.T) {
+	state := testDeviceState()
+	data, err := openpaygotoken.EncodeDeviceState(state, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != openpaygotoken.DeviceStateSize {
+		t.Errorf("Expected %d bytes, got %d", openpaygotoken.DeviceStateSize, len(data))
+	}
+	decoded, sequence, err := openpaygotoken.DecodeDeviceState(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sequence != 5 {
+		t.Errorf("Expected sequence to be 5, got %d", sequence)
+	}
+	if !reflect.DeepEqual(decoded.UsedCounts, state.UsedCounts) || !reflect.DeepEqual(decoded.ExtendedUsedCounts, state.ExtendedUsedCounts) {
+		t.Errorf("Expected used counts %v and %v, got %v and %v", state.UsedCounts, state.ExtendedUsedCounts, decoded.UsedCounts, decoded.ExtendedUsedCounts)
+	}
+	if decoded.Count != state.Count || decoded.ExtendedCount != state.ExtendedCount || decoded.PaygEnabled != state.PaygEnabled ||
+		decoded.InvalidTokenCount != state.InvalidTokenCount || !decoded.ExpirationTimestamp.Equal(state.ExpirationTimestamp) ||
+		!decoded.TokenEntryBlockedUntil.Equal(state.TokenEntryBlockedUntil) || !decoded.FirstInvalidTokenAt.Equal(state.FirstInvalidTokenAt) {
+		t.Errorf("Expected %+v, got %+v", state, decoded)
+	}
+}
+
+func TestDeviceStateCorruption(t *testing.T) {
+	data, err := openpaygotoken.EncodeDeviceState(testDeviceState(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append([]byte{}, data...)
+	corrupt[7] ^= 0x10
+	if _, _, err = openpaygotoken.DecodeDeviceState(corrupt); !errors.Is(err, &openpaygotoken.ErrCorruptState{}) {
+		t.Errorf("Expected ErrCorruptState, got %v", err)
+	}
+	if _, _, err = openpaygotoken.DecodeDeviceState(data[:30]); !errors.Is(err, &openpaygotoken.ErrCorruptState{}) {
+		t.Errorf("Expected ErrCorruptState for truncated data, got %v", err)
+	}
+
+	older := testDeviceState()
+	older.Count = 40
+	olderData, err := openpaygotoken.EncodeDeviceState(older, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := openpaygotoken.LoadDeviceState(openpaygotoken.LockedDeviceState(0), olderData, data)
+	if err != nil || state.Count != 42 {
+		t.Errorf("Expected the newest state, got %+v and %v", state, err)
+	}
+	state, err = openpaygotoken.LoadDeviceState(openpaygotoken.LockedDeviceState(0), olderData, corrupt)
+	if err != nil || state.Count != 40 {
+		t.Errorf("Expected the older valid state, got %+v and %v", state, err)
+	}
+	state, err = openpaygotoken.LoadDeviceState(openpaygotoken.LockedDeviceState(40), corrupt, nil)
+	if !errors.Is(err, &openpaygotoken.ErrCorruptState{}) {
+		t.Errorf("Expected ErrCorruptState, got %v", err)
+	}
+	if state.Count != 40 || !state.PaygEnabled || !state.ExpirationTimestamp.IsZero() {
+		t.Errorf("Expected the locked fallback state, got %+v", state)
+	}
+}
+
+func TestDeviceStateVersion1(t *testing.T) {
+	data, err := openpaygotoken.EncodeDeviceState(testDeviceState(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The version 1 ends with the checksum right after the blocking time.
+	v1 := append([]byte{}, data[:56]...)
+	v1[0] = 1
+	v1 = binary.BigEndian.AppendUint32(v1, crc32.ChecksumIEEE(v1))
+	decoded, sequence, err := openpaygotoken.DecodeDeviceState(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sequence != 3 || decoded.Count != 42 || decoded.InvalidTokenCount != 3 || !decoded.FirstInvalidTokenAt.IsZero() {
+		t.Errorf("Expected the version 1 state without first invalid token time, got %+v", decoded)
+	}
+	data[0] = 9
+	binary.BigEndian.PutUint32(data[64:], crc32.ChecksumIEEE(data[:64]))
+	if _, _, err = openpaygotoken.DecodeDeviceState(data); !errors.Is(err, &openpaygotoken.ErrUnsupportedStateVersion{}) {
+		t.Errorf("Expected ErrUnsupportedStateVersion, got %v", err)
+	}
+}
+
+func TestDeviceStateJSON(t *testing.T) {
+	data, err := json.Marshal(testDeviceState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"used_counts":[26,30,41,42]`) {
+		t.Errorf("Expected the used counts as a list, got %s", data)
+	}
+	var decoded openpaygotoken.DeviceState
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Count != 42 || !reflect.DeepEqual(decoded.UsedCounts, testDeviceState().UsedCounts) {
+		t.Errorf("Expected the JSON form to round trip, got %+v", decoded)
+	}
+}
@@ -0,0 +1,127 @@
+package openpaygotoken_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+	"github.com/wan5xp/openpaygotoken/pkg/simulators"
+)
+
+func TestGenerateTokensFromValue(t *testing.T) {
+	clock := openpaygotoken.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	deviceSimulator, err := simulators.NewDeviceSimulatorWithClock(clock, startingCode, &key, 1, false, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverSimulator := simulators.NewSingleDeviceServerSimulatorWithClock(clock, startingCode, &key, 1, false, 1)
+	tokens, err := serverSimulator.GenerateTokensFromValue(2500, openpaygotoken.SetTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 3 {
+		t.Fatalf("Expected 3 tokens, got %d", len(tokens))
+	}
+	for _, token := range tokens {
+		if err = deviceSimulator.EnterToken(token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if expected := clock.Now().Add(2500 * 24 * time.Hour); !deviceSimulator.ExpirationTimestamp.Equal(expected) {
+		t.Errorf("Expected expiration to be %s, got %s", expected, deviceSimulator.ExpirationTimestamp)
+	}
+	if deviceSimulator.Count != serverSimulator.Count {
+		t.Errorf("Expected device count to be %d, got %d", serverSimulator.Count, deviceSimulator.Count)
+	}
+
+	tokens, err = serverSimulator.GenerateTokensFromValue(0, openpaygotoken.AddTime)
+	if err != nil || len(tokens) != 1 {
+		t.Errorf("Expected a single token for a zero value, got %v and %v", tokens, err)
+	}
+}
+
+func TestGenerateTokensFromDate(t *testing.T) {
+	clock := openpaygotoken.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	deviceSimulator, err := simulators.NewDeviceSimulatorWithClock(clock, startingCode, &key, 1, false, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverSimulator := simulators.NewSingleDeviceServerSimulatorWithClock(clock, startingCode, &key, 1, false, 1)
+	expiration := clock.Now().Add(1200 * 24 * time.Hour)
+	tokens, err := serverSimulator.GenerateTokensFromDate(expiration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 {
+		t.Fatalf("Expected 2 tokens, got %d", len(tokens))
+	}
+	for _, token := range tokens {
+		if err = deviceSimulator.EnterToken(token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !deviceSimulator.ExpirationTimestamp.Equal(expiration) {
+		t.Errorf("Expected expiration to be %s, got %s", expiration, deviceSimulator.ExpirationTimestamp)
+	}
+	expiration = expiration.Add(1000 * 24 * time.Hour)
+	if tokens, err = serverSimulator.GenerateTokensFromDate(expiration); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range tokens {
+		if err = deviceSimulator.EnterToken(token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !deviceSimulator.ExpirationTimestamp.Equal(expiration) {
+		t.Errorf("Expected expiration to be %s, got %s", expiration, deviceSimulator.ExpirationTimestamp)
+	}
+}
+
+func TestGenerateTokensWithTimeDivider(t *testing.T) {
+	for _, timeDivider := range []int{2, 3, 7, 24} {
+		clock := openpaygotoken.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+		deviceSimulator, err := simulators.NewDeviceSimulatorWithClock(clock, startingCode, &key, 1, false, false, timeDivider)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serverSimulator := simulators.NewSingleDeviceServerSimulatorWithClock(clock, startingCode, &key, 1, false, timeDivider)
+		expiration := clock.Now().Add(1000 * 24 * time.Hour)
+		tokens, err := serverSimulator.GenerateTokensFromDate(expiration)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, token := range tokens {
+			if err = deviceSimulator.EnterToken(token); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !deviceSimulator.ExpirationTimestamp.Equal(expiration) {
+			t.Errorf("Time divider %d: expected expiration to be %s, got %s", timeDivider, expiration, deviceSimulator.ExpirationTimestamp)
+		}
+	}
+}
+
+func TestSplitActivationValue(t *testing.T) {
+	cases := []struct {
+		value       int
+		timeDivider int
+		values      []int
+	}{
+		{0, 1, []int{0}},
+		{995, 1, []int{995}},
+		{2500, 1, []int{995, 995, 510}},
+		{2000, 2, []int{994, 994, 12}},
+		{1000, 7, []int{994, 6}},
+		{1500, 1000, []int{995, 505}},
+	}
+	for _, c := range cases {
+		values := openpaygotoken.SplitActivationValue(c.value, c.timeDivider)
+		if fmt.Sprint(values) != fmt.Sprint(c.values) {
+			t.Errorf("Value %d with time divider %d: expected %v, got %v", c.value, c.timeDivider, c.values, values)
+		}
+		if count := openpaygotoken.ActivationTokenCount(c.value, c.timeDivider); count != len(c.values) {
+			t.Errorf("Value %d with time divider %d: expected %d tokens, got %d", c.value, c.timeDivider, len(c.values), count)
+		}
+	}
+}
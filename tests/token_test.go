@@ -0,0 +1,137 @@
+package openpaygotoken_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+)
+
+func TestParseToken(t *testing.T) {
+	tests := []struct {
+		input  string
+		digits string
+		format openpaygotoken.TokenFormat
+		code   uint64
+	}{
+		{"312 690 787", "312690787", openpaygotoken.StandardFormat, 312690787},
+		{"*312-690-787#", "312690787", openpaygotoken.StandardFormat, 312690787},
+		{"213 331 421 312 314", "213331421312314", openpaygotoken.RestrictedStandardFormat, 312690787},
+		{"315154457789", "315154457789", openpaygotoken.ExtendedFormat, 315154457789},
+		{"4444 4444 4444 4444 4444", "44444444444444444444", openpaygotoken.RestrictedExtendedFormat, 1<<40 - 1},
+	}
+	for _, test := range tests {
+		token, err := openpaygotoken.ParseToken(test.input)
+		if err != nil {
+			t.Errorf("Parsing %q: %v", test.input, err)
+			continue
+		}
+		if token.Digits != test.digits || token.Format != test.format || token.Code != test.code {
+			t.Errorf("Parsing %q: expected %s %s %d, got %s %s %d", test.input, test.digits, test.format, test.code, token.Digits, token.Format, token.Code)
+		}
+	}
+}
+
+func TestParseTokenErrors(t *testing.T) {
+	tests := []struct {
+		input   string
+		err     error
+		message string
+	}{
+		{"", &openpaygotoken.ErrEmptyToken{}, "Empty token"},
+		{" - ", &openpaygotoken.ErrEmptyToken{}, "Empty token"},
+		{"31269O787", &openpaygotoken.ErrInvalidTokenCharacter{}, "Invalid token character 'O' at position 5"},
+		{"3126907870", &openpaygotoken.ErrInvalidTokenLength{}, "Invalid token length 10"},
+		{"213331421312315", &openpaygotoken.ErrInvalidRestrictedDigit{}, "Invalid restricted digit '5' at position 14"},
+		{"01234123412341234123", &openpaygotoken.ErrInvalidRestrictedDigit{}, "Invalid restricted digit '0' at position 0"},
+	}
+	for _, test := range tests {
+		_, err := openpaygotoken.ParseToken(test.input)
+		if !errors.Is(err, test.err) {
+			t.Errorf("Parsing %q: expected %T, got %v", test.input, test.err, err)
+		} else if err.Error() != test.message {
+			t.Errorf("Parsing %q: expected the message %q, got %q", test.input, test.message, err.Error())
+		}
+	}
+}
+
+func TestDecodeParsedToken(t *testing.T) {
+	decoder, err := openpaygotoken.NewDecoder()
+	if err != nil {
+		t.Error(err)
+	}
+	token, err := openpaygotoken.ParseToken("213-331-421-312-314")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := decoder.Decode(token, startingCode, &key, 0, &[]int{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Count != 3 || result.Value != openpaygotoken.PAYGDisableValue {
+		t.Errorf("Expected count 3 and value %d, got %+v", openpaygotoken.PAYGDisableValue, result)
+	}
+}
+
+func TestRestrictedExtendedTokenRoundTrip(t *testing.T) {
+	decoder, err := openpaygotoken.NewDecoder()
+	if err != nil {
+		t.Error(err)
+	}
+	values := []int{0, 1, 999998, 999999}
+	for value := 7; value < 1000000; value += 7919 {
+		values = append(values, value)
+	}
+	for _, value := range values {
+		_, tokenString, err := openpaygotoken.GenerateExtendedToken(startingCode, &key, value, 1, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := openpaygotoken.ParseToken(tokenString)
+		if err != nil {
+			t.Fatalf("Parsing %s for value %d: %v", tokenString, value, err)
+		}
+		if token.Format != openpaygotoken.RestrictedExtendedFormat {
+			t.Errorf("Expected format to be %s, got %s", openpaygotoken.RestrictedExtendedFormat, token.Format)
+		}
+		_, plainToken, err := openpaygotoken.GenerateExtendedToken(startingCode, &key, value, 1, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%012d", token.Code) != plainToken {
+			t.Errorf("Expected restricted token %s to match %s, got %012d", tokenString, plainToken, token.Code)
+		}
+		result, err := decoder.Decode(token, startingCode, &key, 0, &[]int{})
+		if err != nil {
+			t.Fatalf("Decoding %s for value %d: %v", tokenString, value, err)
+		}
+		if result.Value != value {
+			t.Errorf("Expected value to be %d, got %d", value, result.Value)
+		}
+	}
+}
+
+func TestRestrictedStandardTokenRoundTrip(t *testing.T) {
+	decoder, err := openpaygotoken.NewDecoder()
+	if err != nil {
+		t.Error(err)
+	}
+	for value := 0; value <= openpaygotoken.CounterSyncValue; value++ {
+		count, tokenString, err := openpaygotoken.GenerateStandardToken(startingCode, &key, value, value%4, openpaygotoken.TokenType(value%2+1), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := openpaygotoken.ParseToken(tokenString)
+		if err != nil {
+			t.Fatalf("Parsing %s for value %d: %v", tokenString, value, err)
+		}
+		result, err := decoder.Decode(token, startingCode, &key, 0, &[]int{})
+		if err != nil {
+			t.Fatalf("Decoding %s for value %d: %v", tokenString, value, err)
+		}
+		if result.Value != value || result.Count != count {
+			t.Errorf("Expected value %d and count %d, got %d and %d", value, count, result.Value, result.Count)
+		}
+	}
+}
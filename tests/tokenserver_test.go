@@ -0,0 +1,239 @@
+package openpaygotoken_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+	"github.com/wan5xp/openpaygotoken/pkg/tokenserver"
+)
+
+// testRepositoryOpeners open the repositories the token server tests run against. The test files of the
+// repositories needing build tags add theirs.
+var testRepositoryOpeners = map[string]func(t *testing.T) tokenserver.DeviceRepository{
+	"memory": func(*testing.T) tokenserver.DeviceRepository {
+		return tokenserver.NewMemoryRepository()
+	},
+	"file": func(t *testing.T) tokenserver.DeviceRepository {
+		return tokenserver.NewFileRepository(filepath.Join(t.TempDir(), "devices.json"))
+	},
+}
+
+func testRepositories(t *testing.T) map[string]tokenserver.DeviceRepository {
+	repositories := make(map[string]tokenserver.DeviceRepository, len(testRepositoryOpeners))
+	for name, open := range testRepositoryOpeners {
+		repositories[name] = open(t)
+	}
+	return repositories
+}
+
+func registerTestDevice(t *testing.T, server *tokenserver.TokenServer, serial string) {
+	_, err := server.RegisterDevice(context.Background(), &tokenserver.DeviceRecord{
+		Serial:       serial,
+		StartingCode: startingCode,
+		Key:          key,
+		TimeDivider:  1,
+		Count:        1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTokenServerFleet(t *testing.T) {
+	for name, repository := range testRepositories(t) {
+		ctx := context.Background()
+		clock := openpaygotoken.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+		server := tokenserver.NewTokenServerWithClock(clock, repository)
+		registerTestDevice(t, server, "B")
+		registerTestDevice(t, server, "A")
+		if _, err := server.RegisterDevice(ctx, &tokenserver.DeviceRecord{Serial: "A", TimeDivider: 1}); !errors.Is(err, &tokenserver.ErrDeviceExists{}) {
+			t.Errorf("%s: expected ErrDeviceExists, got %v", name, err)
+		}
+		if _, err := server.GetDevice(ctx, "C"); !errors.Is(err, &tokenserver.ErrDeviceNotFound{}) {
+			t.Errorf("%s: expected ErrDeviceNotFound, got %v", name, err)
+		}
+
+		device, err := openpaygotoken.NewDeviceWithClock(clock, startingCode, &key, 1, false, false, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens, err := server.GenerateTokensFromDate(ctx, "A", clock.Now().Add(1000*24*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tokens) != 2 || tokens[0].Kind != openpaygotoken.KindAddTime || tokens[1].Count != tokens[0].Count+2 {
+			t.Errorf("%s: expected two add time tokens, got %+v", name, tokens)
+		}
+		for _, token := range tokens {
+			if _, err = device.EnterToken(token.Token); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		record, err := server.GetDevice(ctx, "A")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !record.ExpirationDate.Equal(device.ExpirationTimestamp) || record.Count != device.Count || len(record.History) != 2 {
+			t.Errorf("%s: expected the record to follow the device, got %+v", name, record)
+		}
+		if _, err = server.GeneratePaygDisableToken(ctx, "A"); err != nil {
+			t.Fatal(err)
+		}
+
+		records, err := server.ListDevices(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 2 || records[0].Serial != "A" || records[0].PaygEnabled || records[1].Count != 1 {
+			t.Errorf("%s: expected the records sorted by serial, got %+v", name, records)
+		}
+	}
+}
+
+func TestTokenServerConcurrentIssuance(t *testing.T) {
+	for name, repository := range testRepositories(t) {
+		server := tokenserver.NewTokenServer(repository)
+		registerTestDevice(t, server, "A")
+		registerTestDevice(t, server, "B")
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		counts := make(map[string]map[int]bool)
+		for xn := 0; xn < 40; xn++ {
+			serial := []string{"A", "B"}[xn%2]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				tokens, err := server.GenerateTokensFromValue(context.Background(), serial, 1, openpaygotoken.AddTime)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if counts[serial] == nil {
+					counts[serial] = make(map[int]bool)
+				}
+				if counts[serial][tokens[0].Count] {
+					t.Errorf("%s: count %d issued twice for %s", name, tokens[0].Count, serial)
+				}
+				counts[serial][tokens[0].Count] = true
+			}()
+		}
+		wg.Wait()
+		for _, serial := range []string{"A", "B"} {
+			record, err := server.GetDevice(context.Background(), serial)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if record.Count != 40 || len(record.History) != 20 {
+				t.Errorf("%s: expected count 40 and 20 tokens for %s, got %d and %d", name, serial, record.Count, len(record.History))
+			}
+		}
+	}
+}
+
+func TestTokenServerSyncCounter(t *testing.T) {
+	ctx := context.Background()
+	server := tokenserver.NewTokenServer(tokenserver.NewMemoryRepository())
+	registerTestDevice(t, server, "A")
+	if _, err := server.GenerateTokensFromValue(ctx, "A", 40*995, openpaygotoken.AddTime); err != nil {
+		t.Fatal(err)
+	}
+	token, err := server.SyncCounter(ctx, "A", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == nil || token.Kind != openpaygotoken.KindCounterSync {
+		t.Errorf("Expected a counter sync token, got %+v", token)
+	}
+	if token, err = server.SyncCounter(ctx, "A", token.Count); err != nil || token != nil {
+		t.Errorf("Expected no token once in sync, got %+v and %v", token, err)
+	}
+	if _, err = server.GenerateTokensFromValue(ctx, "A", -1, openpaygotoken.AddTime); !errors.Is(err, &tokenserver.ErrInvalidValue{}) {
+		t.Errorf("Expected ErrInvalidValue, got %v", err)
+	}
+}
+
+func TestTokenServerTimeDivider(t *testing.T) {
+	for name, repository := range testRepositories(t) {
+		ctx := context.Background()
+		clock := openpaygotoken.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+		server := tokenserver.NewTokenServerWithClock(clock, repository)
+		_, err := server.RegisterDevice(ctx, &tokenserver.DeviceRecord{Serial: "A", StartingCode: startingCode, Key: key, TimeDivider: 2, Count: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		device, err := openpaygotoken.NewDeviceWithClock(clock, startingCode, &key, 1, false, false, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens, err := server.GenerateTokensFromDate(ctx, "A", clock.Now().Add(1000*24*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tokens) != 3 || tokens[0].Value != 994 || tokens[2].Value != 12 {
+			t.Errorf("%s: expected three tokens split on the time divider, got %+v", name, tokens)
+		}
+		for _, token := range tokens {
+			if _, err = device.EnterToken(token.Token); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		record, err := server.GetDevice(ctx, "A")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !record.ExpirationDate.Equal(device.ExpirationTimestamp) || !device.ExpirationTimestamp.Equal(clock.Now().Add(1000*24*time.Hour)) {
+			t.Errorf("%s: expected the device and the record to expire in 1000 days, got %v and %v", name, device.ExpirationTimestamp, record.ExpirationDate)
+		}
+		if record.Chain == nil || len(record.Chain.Checkpoints) == 0 {
+			t.Errorf("%s: expected the token chain to be saved, got %+v", name, record.Chain)
+		}
+	}
+}
+
+func TestTokenServerMaxBundleTokens(t *testing.T) {
+	ctx := context.Background()
+	server := tokenserver.NewTokenServer(tokenserver.NewMemoryRepository())
+	server.MaxBundleTokens = 3
+	registerTestDevice(t, server, "A")
+	if _, err := server.GenerateTokensFromValue(ctx, "A", 3*995+1, openpaygotoken.AddTime); !errors.Is(err, &tokenserver.ErrInvalidValue{}) {
+		t.Errorf("Expected ErrInvalidValue, got %v", err)
+	}
+	if _, err := server.GenerateTokensFromDate(ctx, "A", time.Now().Add(3000*24*time.Hour)); !errors.Is(err, &tokenserver.ErrInvalidValue{}) {
+		t.Errorf("Expected ErrInvalidValue, got %v", err)
+	}
+	tokens, err := server.GenerateTokensFromValue(ctx, "A", 3*995, openpaygotoken.AddTime)
+	if err != nil || len(tokens) != 3 {
+		t.Errorf("Expected three tokens, got %+v and %v", tokens, err)
+	}
+	record, err := server.GetDevice(ctx, "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Count != 6 || len(record.History) != 3 {
+		t.Errorf("Expected the rejected bundles to issue no token, got %+v", record)
+	}
+}
+
+func TestTokenServerInvalidDevice(t *testing.T) {
+	server := tokenserver.NewTokenServer(tokenserver.NewMemoryRepository())
+	devices := []*tokenserver.DeviceRecord{
+		{Serial: "", StartingCode: startingCode, TimeDivider: 1},
+		{Serial: "A", StartingCode: -1, TimeDivider: 1},
+		{Serial: "A", StartingCode: 1000000000, TimeDivider: 1},
+		{Serial: "A", StartingCode: startingCode, TimeDivider: 0},
+		{Serial: "A", StartingCode: startingCode, TimeDivider: -2},
+		{Serial: "A", StartingCode: startingCode, TimeDivider: 1, Count: -1},
+	}
+	for _, device := range devices {
+		if _, err := server.RegisterDevice(context.Background(), device); !errors.Is(err, &tokenserver.ErrInvalidDevice{}) {
+			t.Errorf("Expected ErrInvalidDevice for %+v, got %v", device, err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package openpaygotoken_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/wan5xp/openpaygotoken/pkg/openpaygotoken"
+)
+
+func newStoredDevice(t *testing.T, clock openpaygotoken.Clock, storage openpaygotoken.Storage) *openpaygotoken.Device {
+	device, err := openpaygotoken.NewDeviceWithClock(clock, startingCode, &key, 1, false, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device.Storage = storage
+	if err = device.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	return device
+}
+
+func TestInterruptedTokenApplication(t *testing.T) {
+	// The device writes the journal, then the state, then empties the journal.
+	tests := []struct {
+		name          string
+		writesBefore  int
+		expectApplied bool
+	}{
+		{"journal torn", 0, false},
+		{"state torn", 1, true},
+		{"journal not emptied", 2, true},
+	}
+	for _, test := range tests {
+		clock := openpaygotoken.NewFakeClock(time.Unix(1700000000, 0))
+		storage := openpaygotoken.NewMemoryStorage()
+		device := newStoredDevice(t, clock, storage)
+		_, token, err := openpaygotoken.GenerateStandardToken(startingCode, &key, 10, 1, openpaygotoken.SetTime, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		storage.LosePowerAfter(test.writesBefore)
+		if _, err = device.EnterToken(token); !errors.Is(err, &openpaygotoken.ErrPowerLost{}) {
+			t.Fatalf("%s: expected ErrPowerLost, got %v", test.name, err)
+		}
+		storage.PowerOn()
+		clock.Advance(time.Hour)
+		device = newStoredDevice(t, clock, storage)
+		if device.IsActive() != test.expectApplied {
+			t.Errorf("%s: expected the token to be applied: %t", test.name, test.expectApplied)
+		}
+		if test.expectApplied {
+			if device.Count != 3 {
+				t.Errorf("%s: expected count to be 3, got %d", test.name, device.Count)
+			}
+			if expected := time.Unix(1700000000, 0).Add(10 * 24 * time.Hour); !device.ExpirationTimestamp.Equal(expected) {
+				t.Errorf("%s: expected expiration to be %s, got %s", test.name, expected, device.ExpirationTimestamp)
+			}
+			if _, err = device.EnterToken(token); !errors.Is(err, &openpaygotoken.ErrValidOlderToken{}) {
+				t.Errorf("%s: expected ErrValidOlderToken, got %v", test.name, err)
+			}
+		} else if _, err = device.EnterToken(token); err != nil {
+			t.Errorf("%s: expected the token to be accepted again, got %v", test.name, err)
+		}
+		device = newStoredDevice(t, clock, storage)
+		if !device.IsActive() || device.Count != 3 {
+			t.Errorf("%s: expected the saved state to be active with count 3, got %+v", test.name, device.DeviceState)
+		}
+	}
+}
+
+func TestRecoverCorruptState(t *testing.T) {
+	storage := openpaygotoken.NewMemoryStorage()
+	for _, name := range []string{"state.0", "state.1"} {
+		if err := storage.Write(name, []byte{1, 2, 3}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	device, err := openpaygotoken.NewDevice(startingCode, &key, 5, false, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device.Storage = storage
+	if err = device.Recover(); !errors.Is(err, &openpaygotoken.ErrCorruptState{}) {
+		t.Errorf("Expected ErrCorruptState, got %v", err)
+	}
+	if device.IsActive() || device.Count != 5 {
+		t.Errorf("Expected a locked device with count 5, got %+v", device.DeviceState)
+	}
+	device = newStoredDevice(t, openpaygotoken.RealClock{}, storage)
+	if device.Count != 5 {
+		t.Errorf("Expected the fallback state to be saved, got %+v", device.DeviceState)
+	}
+}
+
+func TestRecoverWithoutStorage(t *testing.T) {
+	device, err := openpaygotoken.NewDevice(startingCode, &key, 5, false, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = device.Recover(); err != nil || device.Count != 5 {
+		t.Errorf("Expected the state to be kept, got count %d and %v", device.Count, err)
+	}
+}